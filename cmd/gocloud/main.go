@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/alecthomas/kong"
 	"github.com/rjkroege/gocloud/config"
@@ -14,37 +19,137 @@ import (
 var CLI struct {
 	ConfigFile string `type:"path" help:"Set alternate configuration file" default:"~/.config/gocloud/gocloud.toml"`
 	Debug      bool   `help:"Additional logging for debugging"`
+	Endpoint   string `hidden:"" help:"Use this Compute Engine API endpoint without authentication (for testing)"`
+	SshPort    int    `hidden:"" help:"Connect to new nodes on this ssh port (for testing)"`
+	Format     string `help:"Output format for ls, describe, ls-images and show-meta: table, json, yaml or a Go template" default:"table"`
 
 	Make struct {
-		Config string `arg name:"config" help:"Defined configuration for instance"`
-		Name   string `arg name:"name" help:"Name of instance"`
-	} `cmd help:"Make instance."`
+		Config        string `arg:"" name:"config" help:"Defined configuration for instance"`
+		Name          string `arg:"" name:"name" help:"Name of instance or, with --count, a template such as build-{{.Index}} or dev-{{.Random}}"`
+		Count         int    `help:"Make this many nodes, naming them with the name template." default:"1"`
+		Parallel      int    `help:"Make at most this many nodes at once." default:"4"`
+		KeepOnFailure bool   `help:"Keep the node for debugging if making or configuring it fails."`
+		Replace       bool   `help:"Delete and remake nodes that already exist."`
+	} `cmd:"" help:"Make instance."`
 
 	Del struct {
-		Node string `arg name:"node" help:"Node to remove."`
-	} `cmd help:"Delete node." aliases:"rm"`
+		Node string `arg:"" name:"node" help:"Node to remove."`
+		Zone string `help:"Zone of the node. By default, gocloud finds it."`
+	} `cmd:"" help:"Delete node." aliases:"rm"`
+
+	Stop struct {
+		Node string `arg:"" name:"node" help:"Node to stop."`
+		Zone string `help:"Zone of the node. By default, gocloud finds it."`
+	} `cmd:"" help:"Stop node, keeping its boot disk."`
+
+	Start struct {
+		Node string `arg:"" name:"node" help:"Node to start."`
+		Zone string `help:"Zone of the node. By default, gocloud finds it."`
+	} `cmd:"" help:"Start a stopped node."`
+
+	Suspend struct {
+		Node string `arg:"" name:"node" help:"Node to suspend."`
+		Zone string `help:"Zone of the node. By default, gocloud finds it."`
+	} `cmd:"" help:"Suspend node, keeping its memory and boot disk."`
+
+	Resume struct {
+		Node string `arg:"" name:"node" help:"Node to resume."`
+		Zone string `help:"Zone of the node. By default, gocloud finds it."`
+	} `cmd:"" help:"Resume a suspended node."`
+
+	Reset struct {
+		Node string `arg:"" name:"node" help:"Node to reset."`
+		Zone string `help:"Zone of the node. By default, gocloud finds it."`
+	} `cmd:"" help:"Reset (hard reboot) node."`
+
+	AttachDisk struct {
+		Node     string `arg:"" name:"node" help:"Node to attach the disk to."`
+		Disk     string `arg:"" name:"disk" help:"Disk to attach."`
+		Zone     string `help:"Zone of the node. By default, gocloud finds it."`
+		ReadOnly bool   `help:"Attach the disk read-only."`
+		Size     int64  `help:"Make the disk with this size in GB if it doesn't exist."`
+		Type     string `help:"Disk type of a new disk." default:"pd-balanced"`
+	} `cmd:"" help:"Attach a persistent disk to node, making it if necessary."`
+
+	DetachDisk struct {
+		Node string `arg:"" name:"node" help:"Node to detach the disk from."`
+		Disk string `arg:"" name:"disk" help:"Disk to detach."`
+		Zone string `help:"Zone of the node. By default, gocloud finds it."`
+	} `cmd:"" help:"Detach a persistent disk from node, keeping the disk."`
 
 	Describe struct {
-		Name string `arg name:"name" help:"Name of instance"`
-	} `cmd help:"Describe a specific node"`
+		Name        string `arg:"" name:"name" help:"Name of instance"`
+		Zone        string `help:"Zone of the node. By default, gocloud finds it."`
+		ShowSecrets bool   `help:"Show secret metadata values instead of redacting them"`
+	} `cmd:"" help:"Describe a specific node"`
 
 	Ls struct {
-	} `cmd help:"List running nodes."`
+		Label []string `help:"Only list nodes with this key=value label. Can be repeated."`
+		Mine  bool     `help:"Only list nodes that you made."`
+	} `cmd:"" help:"List nodes in every zone."`
+
+	SyncSsh struct {
+		DryRun bool `help:"Show the changes to ~/.ssh/config without making them."`
+	} `cmd:"" help:"Update the ssh aliases in ~/.ssh/config for the nodes in every zone."`
 
 	LsImages struct {
-	} `cmd help:"List available images."`
+	} `cmd:"" help:"List available images."`
+
+	ShowConfig struct {
+		Name string `arg:"" name:"name" help:"Defined configuration for instance"`
+	} `cmd:"" help:"Show configuration with inherited settings filled in"`
+
+	CheckConfig struct {
+		Offline bool `help:"Only check the file, not the service accounts that it names."`
+	} `cmd:"" help:"Check the configuration file for mistakes."`
 
 	ShowMeta struct {
-		Config string `arg name:"config" help:"Defined configuration for instance"`
-	} `cmd help:"Show metadata for configuration"`
+		Config      string `arg:"" name:"config" help:"Defined configuration for instance"`
+		ShowSecrets bool   `help:"Show secret metadata values instead of redacting them"`
+	} `cmd:"" help:"Show metadata for configuration"`
 }
 
 func main() {
 	ctx := kong.Parse(&CLI)
 
 	settings, err := config.Read(CLI.ConfigFile)
+	if ctx.Command() == "check-config" {
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if !CLI.CheckConfig.Offline {
+			settings.Endpoint = CLI.Endpoint
+			problems, err := gcp.CheckServiceAccounts(settings)
+			if err != nil {
+				fmt.Println("can't check service accounts:", err)
+				os.Exit(1)
+			}
+			if len(problems) > 0 {
+				fmt.Println(problems)
+				os.Exit(1)
+			}
+		}
+		fmt.Println(CLI.ConfigFile, "is ok")
+		return
+	}
+	var problems config.Problems
+	if errors.As(err, &problems) && settings != nil && !problemsAreFatal(ctx.Command()) {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, "warning:", p)
+		}
+		err = nil
+	}
 	if err != nil {
-		fmt.Println("Fatai:", err)
+		fmt.Println("Fatal:", err)
+		os.Exit(-1)
+	}
+	settings.Endpoint = CLI.Endpoint
+	settings.SshPort = CLI.SshPort
+
+	format, err := gcp.ParseFormat(CLI.Format)
+	if err != nil {
+		fmt.Println("Fatal:", err)
 		os.Exit(-1)
 	}
 
@@ -55,17 +160,32 @@ func main() {
 			litter.Dump(settings)
 		}
 
-		if err := gcp.List(settings); err != nil {
+		filter, err := gcp.ParseLabelFilter(CLI.Ls.Label, CLI.Ls.Mine)
+		if err != nil {
+			fmt.Println("Fatal:", err)
+			os.Exit(-1)
+		}
+		if err := gcp.List(settings, format, filter); err != nil {
 			fmt.Println("can't list nodes:", err)
 			os.Exit(-1)
 		}
+	case "sync-ssh":
+		if CLI.Debug {
+			log.Println("sync-ssh", "using", CLI.ConfigFile, ":")
+			litter.Dump(settings)
+		}
+
+		if err := gcp.SyncSsh(settings, os.Stdout, CLI.SyncSsh.DryRun); err != nil {
+			fmt.Println("can't sync ssh aliases:", err)
+			os.Exit(-1)
+		}
 	case "ls-images":
 		if CLI.Debug {
 			log.Println("lsimages", "using", CLI.ConfigFile, ":")
 			litter.Dump(settings)
 		}
 
-		if err := gcp.ListImages(settings); err != nil {
+		if err := gcp.ListImages(settings, format); err != nil {
 			fmt.Println("can't list images:", err)
 			os.Exit(-1)
 		}
@@ -76,32 +196,53 @@ func main() {
 		}
 
 		// TODO(rjk): There's probably some fancy Kong way to do this that's better.
-		if _, ok := settings.InstanceTypes[CLI.Make.Config]; !ok {
-			fmt.Printf("undefined instance type %q\n", CLI.Make.Config)
+		if _, err := settings.Instance(CLI.Make.Config); err != nil {
+			fmt.Println(err)
 			os.Exit(-1)
 		}
 
-		ni, err := gcp.MakeNode(settings, CLI.Make.Config, CLI.Make.Name)
+		names, err := config.NodeNames(CLI.Make.Name, CLI.Make.Count)
 		if err != nil {
-			fmt.Println("can't make node:", err)
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		if CLI.Make.Parallel < 1 {
+			fmt.Println("--parallel must be at least 1")
 			os.Exit(-1)
 		}
 
-		// Wait for the Ssh server to be running.
-		client, err := gcp.WaitForSsh(settings, ni)
+		client, err := gcp.NewClient(context.Background(), settings)
 		if err != nil {
-			fmt.Printf("no ssh ever came up: %v", err)
+			fmt.Println("can't make client:", err)
 			os.Exit(-1)
 		}
-		defer client.Close()
+		client.Progress = os.Stdout
 
-		if err := gcp.ConfigureViaSsh(settings, ni, client); err != nil {
-			fmt.Printf("ConfigureViaSsh failed: %v", err)
-			// Should I exit here?
+		names, err = existingNodes(client, settings, CLI.Make.Config, names, CLI.Make.Replace, os.Stdin)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
 		}
 
-		if err := config.AddSshAlias(ni.Name, ni.Addr); err != nil {
-			fmt.Printf("can't update ssh for node %v: %v", ni, err)
+		// Interrupting gocloud while it makes the nodes cleans them up
+		// instead of leaving them half configured. A second interrupt
+		// while cleaning up exits right away.
+		sigctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		context.AfterFunc(sigctx, stop)
+		results := makeNodes(sigctx, client, settings, CLI.Make.Config, names, CLI.Make.Parallel, CLI.Make.KeepOnFailure)
+		stop()
+
+		failed := false
+		for _, r := range results {
+			if r.err != nil {
+				failed = true
+			}
+		}
+		if len(results) > 1 {
+			printMakeResults(os.Stdout, results)
+		}
+		if failed {
+			os.Exit(-1)
 		}
 	case "del <node>":
 		if CLI.Debug {
@@ -109,31 +250,124 @@ func main() {
 			litter.Dump(settings)
 		}
 
-		if err := gcp.EndSession(settings, CLI.Del.Node); err != nil {
+		if err := gcp.EndSession(settings, CLI.Del.Zone, CLI.Del.Node); err != nil {
 			fmt.Printf("can't remove instance %s: %v", CLI.Del.Node, err)
 			os.Exit(-1)
 		}
+	case "stop <node>", "suspend <node>":
+		if CLI.Debug {
+			log.Println(ctx.Command(), "using", CLI.ConfigFile, ":")
+			litter.Dump(settings)
+		}
+
+		client, err := gcp.NewClient(context.Background(), settings)
+		if err != nil {
+			fmt.Println("can't make client:", err)
+			os.Exit(-1)
+		}
+		client.Progress = os.Stdout
+		node := CLI.Stop.Node
+		if ctx.Command() == "stop <node>" {
+			err = client.Stop(CLI.Stop.Zone, CLI.Stop.Node)
+		} else {
+			node = CLI.Suspend.Node
+			err = client.Suspend(CLI.Suspend.Zone, CLI.Suspend.Node)
+		}
+		if err != nil {
+			fmt.Printf("can't %s %s: %v\n", strings.Fields(ctx.Command())[0], node, err)
+			os.Exit(-1)
+		}
+	case "start <node>", "resume <node>", "reset <node>":
+		if CLI.Debug {
+			log.Println(ctx.Command(), "using", CLI.ConfigFile, ":")
+			litter.Dump(settings)
+		}
+
+		client, err := gcp.NewClient(context.Background(), settings)
+		if err != nil {
+			fmt.Println("can't make client:", err)
+			os.Exit(-1)
+		}
+		client.Progress = os.Stdout
+		var ni *gcp.NodeInfo
+		var node string
+		switch ctx.Command() {
+		case "start <node>":
+			node = CLI.Start.Node
+			ni, err = client.Start(CLI.Start.Zone, node)
+		case "resume <node>":
+			node = CLI.Resume.Node
+			ni, err = client.Resume(CLI.Resume.Zone, node)
+		case "reset <node>":
+			node = CLI.Reset.Node
+			ni, err = client.Reset(CLI.Reset.Zone, node)
+		}
+		if err != nil {
+			fmt.Printf("can't %s %s: %v\n", strings.Fields(ctx.Command())[0], node, err)
+			os.Exit(-1)
+		}
+
+		// The node may have a new ephemeral address.
+		if err := reconnect(settings, ni); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	case "attach-disk <node> <disk>", "detach-disk <node> <disk>":
+		if CLI.Debug {
+			log.Println(ctx.Command(), "using", CLI.ConfigFile, ":")
+			litter.Dump(settings)
+		}
+
+		client, err := gcp.NewClient(context.Background(), settings)
+		if err != nil {
+			fmt.Println("can't make client:", err)
+			os.Exit(-1)
+		}
+		client.Progress = os.Stdout
+		if ctx.Command() == "attach-disk <node> <disk>" {
+			err = client.AttachDisk(CLI.AttachDisk.Zone, CLI.AttachDisk.Node, CLI.AttachDisk.Disk, gcp.DiskOptions{
+				ReadOnly: CLI.AttachDisk.ReadOnly,
+				SizeGb:   CLI.AttachDisk.Size,
+				Type:     CLI.AttachDisk.Type,
+			})
+		} else {
+			err = client.DetachDisk(CLI.DetachDisk.Zone, CLI.DetachDisk.Node, CLI.DetachDisk.Disk)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
 	case "show-meta <config>":
 		if CLI.Debug {
 			log.Println("ShowMetadata", "using", CLI.ConfigFile, ":")
 			litter.Dump(settings)
 		}
 
-		if _, ok := settings.InstanceTypes[CLI.ShowMeta.Config]; !ok {
-			fmt.Printf("undefined instance type %q\n", CLI.ShowMeta.Config)
+		if _, err := settings.Instance(CLI.ShowMeta.Config); err != nil {
+			fmt.Println(err)
 			os.Exit(-1)
 		}
-		if err := gcp.ShowMetadata(settings, CLI.ShowMeta.Config); err != nil {
+		if err := gcp.ShowMetadata(settings, CLI.ShowMeta.Config, format, CLI.ShowMeta.ShowSecrets); err != nil {
 			fmt.Printf("can't show metadata for config %s: %v\n", CLI.ShowMeta.Config, err)
 			os.Exit(-1)
 		}
+	case "show-config <name>":
+		if CLI.Debug {
+			log.Println("ShowConfig", "using", CLI.ConfigFile, ":")
+			litter.Dump(settings)
+		}
+
+		if err := settings.WriteInstance(os.Stdout, CLI.ShowConfig.Name); err != nil {
+			fmt.Printf("can't show config %s: %v\n", CLI.ShowConfig.Name, err)
+			os.Exit(-1)
+		}
 	case "describe <name>":
 		if CLI.Debug {
 			log.Println("DescribeInstance", "using", CLI.ConfigFile, ":")
 			litter.Dump(settings)
 		}
 
-		if err := gcp.DescribeInstance(settings, CLI.Describe.Name); err != nil {
+		if err := gcp.DescribeInstance(settings, CLI.Describe.Zone, CLI.Describe.Name, format, CLI.Describe.ShowSecrets); err != nil {
 			fmt.Printf("can't describe %s: %v\n", CLI.Describe.Name, err)
 			os.Exit(-1)
 		}
@@ -141,3 +375,30 @@ func main() {
 		panic(ctx.Command())
 	}
 }
+
+// reconnect waits for the restarted node described by ni to run ssh,
+// verifies that it is the node that gocloud made and points the node's
+// ssh alias at its current address.
+func reconnect(settings *config.Settings, ni *gcp.NodeInfo) error {
+	client, err := gcp.WaitForSsh(settings, ni)
+	if err != nil {
+		return fmt.Errorf("no ssh ever came up: %v", err)
+	}
+	defer client.Close()
+
+	if err := gcp.CheckToken(ni, client); err != nil {
+		return err
+	}
+
+	if err := config.AddSshAlias(settings.ProjectId, ni.Name, ni.Addr, ni.JumpHost, ni.HostKey); err != nil {
+		return fmt.Errorf("can't update ssh for node %s: %v", ni.Name, err)
+	}
+	return nil
+}
+
+// problemsAreFatal returns true if command depends on every setting in
+// the configuration file so that a problem with any of them stops it.
+// Other commands only warn about the problems.
+func problemsAreFatal(command string) bool {
+	return strings.HasPrefix(command, "make ") || strings.HasPrefix(command, "show-")
+}
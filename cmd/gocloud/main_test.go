@@ -0,0 +1,734 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/gocloud/config"
+	"github.com/rjkroege/gocloud/gcp/fakecompute"
+	"github.com/rjkroege/gocloud/gcp/sshtest"
+	"golang.org/x/crypto/ssh"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// TestMain lets the tests run the gocloud command by re-executing the
+// test binary with GOCLOUD_RUN_MAIN set.
+func TestMain(m *testing.M) {
+	if os.Getenv("GOCLOUD_RUN_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+const testconfig = `
+defaultzone = "us-east1-b"
+projectid = "testproject"
+defaultuserdata = "#cloud-config\nbootcmd:\n- curl -sf -H Metadata-Flavor:Google -o /etc/ssh/ssh_host_ed25519_key http://metadata.google.internal/computeMetadata/v1/instance/attributes/sshhostkey\n"
+
+[instance.small]
+	hardware = "e2-small"
+	family = "cos-cloud"
+	hostkey = true
+`
+
+// gocloud runs the gocloud command against fake with an empty home
+// directory and returns its combined output.
+func gocloud(t *testing.T, fake *fakecompute.Server, args ...string) (string, error) {
+	t.Helper()
+	return gocloudEnv(t, fake, testconfig, []string{"HOME=" + t.TempDir()}, args...)
+}
+
+// gocloudEnv runs the gocloud command with configuration config and
+// additional environment variables env.
+func gocloudEnv(t *testing.T, fake *fakecompute.Server, config string, env []string, args ...string) (string, error) {
+	t.Helper()
+	return gocloudInput(t, fake, config, env, "", args...)
+}
+
+// gocloudInput is gocloudEnv with input on the command's standard input.
+func gocloudInput(t *testing.T, fake *fakecompute.Server, config string, env []string, input string, args ...string) (string, error) {
+	t.Helper()
+	cmd := gocloudCmd(t, fake, config, env, args...)
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// gocloudStdout is gocloudEnv that returns only the standard output.
+func gocloudStdout(t *testing.T, fake *fakecompute.Server, config string, env []string, args ...string) (string, error) {
+	t.Helper()
+	out, err := gocloudCmd(t, fake, config, env, args...).Output()
+	return string(out), err
+}
+
+// gocloudCmd returns the command that runs gocloud with configuration
+// config and additional environment variables env.
+func gocloudCmd(t *testing.T, fake *fakecompute.Server, config string, env []string, args ...string) *exec.Cmd {
+	t.Helper()
+	cfg := filepath.Join(t.TempDir(), "gocloud.toml")
+	if err := os.WriteFile(cfg, []byte(config), 0600); err != nil {
+		t.Fatalf("can't write config: %v", err)
+	}
+
+	args = append([]string{"--config-file", cfg, "--endpoint", fake.Endpoint()}, args...)
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(append(os.Environ(), "GOCLOUD_RUN_MAIN=1"), env...)
+	return cmd
+}
+
+func newFake(t *testing.T) *fakecompute.Server {
+	fake := fakecompute.NewServer("testproject", "us-east1-b")
+	t.Cleanup(fake.Close)
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name:        "worker",
+		MachineType: "https://www.googleapis.com/compute/v1/projects/testproject/zones/us-east1-b/machineTypes/e2-small",
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{AccessConfigs: []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT", NatIP: "192.0.2.7"}}},
+		},
+	})
+	return fake
+}
+
+func TestLs(t *testing.T) {
+	fake := newFake(t)
+
+	out, err := gocloud(t, fake, "ls")
+	if err != nil {
+		t.Fatalf("gocloud ls failed: %v\n%s", err, out)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("gocloud ls got %d lines, want %d:\n%s", got, want, out)
+	}
+	if got, want := strings.Fields(lines[0]), []string{"NAME", "ZONE", "STATUS", "MACHINE", "CREATED", "CONFIG", "IP"}; !cmp.Equal(got, want) {
+		t.Errorf("gocloud ls header got %q, want %q", got, want)
+	}
+	f := strings.Fields(lines[1])
+	if got, want := append(f[:4:4], f[6:]...), []string{"worker", "us-east1-b", "RUNNING", "e2-small", "-", "192.0.2.7"}; !cmp.Equal(got, want) {
+		t.Errorf("gocloud ls row got %q, want %q", got, want)
+	}
+
+	out, err = gocloud(t, fake, "--format", "{{.Name}} {{.Addr}}", "ls")
+	if err != nil {
+		t.Fatalf("gocloud ls failed: %v\n%s", err, out)
+	}
+	if got, want := out, "worker 192.0.2.7\n"; got != want {
+		t.Errorf("gocloud --format template ls got %q, want %q", got, want)
+	}
+}
+
+func TestLsLabel(t *testing.T) {
+	fake := newFake(t)
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name:        "tagged",
+		MachineType: "https://www.googleapis.com/compute/v1/projects/testproject/zones/us-east1-b/machineTypes/e2-small",
+		Labels:      map[string]string{"team": "infra", "gocloud-user": "someone-else"},
+	})
+
+	out, err := gocloud(t, fake, "--format", "{{.Name}}", "ls", "--label", "team=infra")
+	if err != nil {
+		t.Fatalf("gocloud ls --label failed: %v\n%s", err, out)
+	}
+	if got, want := out, "tagged\n"; got != want {
+		t.Errorf("gocloud ls --label got %q, want %q", got, want)
+	}
+
+	out, err = gocloud(t, fake, "--format", "{{.Name}}", "ls", "--mine")
+	if err != nil {
+		t.Fatalf("gocloud ls --mine failed: %v\n%s", err, out)
+	}
+	if got, want := out, ""; got != want {
+		t.Errorf("gocloud ls --mine got %q, want %q", got, want)
+	}
+
+	if out, err := gocloud(t, fake, "ls", "--label", "team"); err == nil {
+		t.Errorf("gocloud ls with a label without a value should fail:\n%s", out)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	fake := newFake(t)
+
+	out, err := gocloud(t, fake, "describe", "worker")
+	if err != nil {
+		t.Fatalf("gocloud describe failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "192.0.2.7") {
+		t.Errorf("gocloud describe output doesn't include the address:\n%s", out)
+	}
+
+	out, err = gocloud(t, fake, "--format", "json", "describe", "worker")
+	if err != nil {
+		t.Fatalf("gocloud describe failed: %v\n%s", err, out)
+	}
+	var desc map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &desc); err != nil {
+		t.Fatalf("gocloud --format json describe isn't json: %v\n%s", err, out)
+	}
+	if got, want := desc["addr"], "192.0.2.7"; got != want {
+		t.Errorf("gocloud --format json describe addr got %v, want %v", got, want)
+	}
+
+	if out, err := gocloud(t, fake, "describe", "missing"); err == nil {
+		t.Errorf("gocloud describe of a missing node should fail:\n%s", out)
+	}
+}
+
+func TestDel(t *testing.T) {
+	fake := newFake(t)
+
+	out, err := gocloud(t, fake, "del", "worker")
+	if err != nil {
+		t.Fatalf("gocloud del failed: %v\n%s", err, out)
+	}
+	if fake.Instance("us-east1-b", "worker") != nil {
+		t.Error("gocloud del didn't delete the instance")
+	}
+
+	if out, err := gocloud(t, fake, "del", "worker"); err == nil {
+		t.Errorf("gocloud del of a missing node should fail:\n%s", out)
+	}
+}
+
+// newSshFake returns a fake Compute Engine server whose instances are
+// reachable over ssh at the returned ssh server, and a configuration
+// using a key that the ssh server accepts. The ssh server's metadata
+// service serves the metadata of the instance name.
+func newSshFake(t *testing.T, name string) (*fakecompute.Server, *sshtest.Server, string) {
+	t.Helper()
+	fake := fakecompute.NewServer("testproject", "us-east1-b")
+	t.Cleanup(fake.Close)
+	fake.NatIP = "127.0.0.1"
+	fake.AddImages("cos-cloud", &compute.Image{Name: "cos-stable-105-17412-156-59"})
+
+	keydir := t.TempDir()
+	pub, err := sshtest.WriteKeyPair(keydir)
+	if err != nil {
+		t.Fatalf("WriteKeyPair: %v", err)
+	}
+	srv, err := sshtest.NewServer(t.TempDir(), pub)
+	if err != nil {
+		t.Fatalf("sshtest.NewServer: %v", err)
+	}
+	t.Cleanup(srv.Close)
+
+	// The node's metadata service serves what gocloud gave the instance.
+	srv.SetMetadata(instanceMetadata(fake, name))
+
+	config := fmt.Sprintf("sshpublickey = %q\nsshprivatekey = %q\n",
+		filepath.Join(keydir, "id_test.pub"), filepath.Join(keydir, "id_test")) + testconfig
+	return fake, srv, config
+}
+
+// instanceMetadata returns a metadata function serving the metadata that
+// fake holds for the instance name.
+func instanceMetadata(fake *fakecompute.Server, name string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		inst := fake.Instance("us-east1-b", name)
+		if inst == nil {
+			return "", false
+		}
+		for _, it := range inst.Metadata.Items {
+			if it.Key == key {
+				return *it.Value, true
+			}
+		}
+		return "", false
+	}
+}
+
+func TestMake(t *testing.T) {
+	fake, srv, config := newSshFake(t, "builder")
+
+	home := t.TempDir()
+	out, err := gocloudEnv(t, fake, config, []string{"HOME=" + home},
+		"--ssh-port", strconv.Itoa(srv.Port()), "make", "small", "builder")
+	if err != nil {
+		t.Fatalf("gocloud make failed: %v\n%s", err, out)
+	}
+
+	if fake.Instance("us-east1-b", "builder") == nil {
+		t.Error("gocloud make didn't make the instance")
+	}
+	if got := srv.Commands(); len(got) != 3 || got[0] != "cd / ; tar xzf -" {
+		t.Errorf("gocloud make ran unexpected commands %q", got)
+	}
+
+	sshconfig, err := os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		t.Fatalf("gocloud make didn't write an ssh config: %v", err)
+	}
+	if !strings.Contains(string(sshconfig), "Host builder\n\tHostName 127.0.0.1\n") {
+		t.Errorf("ssh config has no alias for builder:\n%s", sshconfig)
+	}
+	if !strings.Contains(string(sshconfig), "\tHostKeyAlias builder\n") {
+		t.Errorf("ssh config doesn't pin the host key for builder:\n%s", sshconfig)
+	}
+
+	// The pinned key is the one that gocloud delivered to the node.
+	knownhosts, err := os.ReadFile(filepath.Join(home, ".ssh", "gocloud_known_hosts"))
+	if err != nil {
+		t.Fatalf("gocloud make didn't pin the host key: %v", err)
+	}
+	hostkey, ok := srv.Metadata(sshtest.HostKeyMetadata)
+	if !ok {
+		t.Fatal("gocloud make didn't deliver a host key")
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(hostkey))
+	if err != nil {
+		t.Fatalf("bad delivered host key: %v", err)
+	}
+	if got, want := string(knownhosts), "builder "+string(ssh.MarshalAuthorizedKey(signer.PublicKey())); got != want {
+		t.Errorf("gocloud_known_hosts got %q, want %q", got, want)
+	}
+
+	out, err = gocloudEnv(t, fake, config, []string{"HOME=" + home}, "del", "builder")
+	if err != nil {
+		t.Fatalf("gocloud del failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "delete of builder in us-east1-b: done") {
+		t.Errorf("gocloud del didn't report progress:\n%s", out)
+	}
+	sshconfig, err = os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		t.Fatalf("can't read ssh config: %v", err)
+	}
+	if strings.Contains(string(sshconfig), "builder") {
+		t.Errorf("gocloud del left the alias for builder:\n%s", sshconfig)
+	}
+}
+
+func TestMakeFailure(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		fake, srv, config := newSshFake(t, "builder")
+		// The node answers with another node's token so configuring it fails.
+		metadata := instanceMetadata(fake, "builder")
+		srv.SetMetadata(func(key string) (string, bool) {
+			if key == "instancetoken" {
+				return "hijacker-token", true
+			}
+			return metadata(key)
+		})
+		fake.SetSerialPortOutput("us-east1-b", "builder", "cloud-init: bootcmd failed\n")
+
+		args := []string{"--ssh-port", strconv.Itoa(srv.Port()), "make", "small", "builder"}
+		if keep {
+			args = append(args, "--keep-on-failure")
+		}
+		out, err := gocloudEnv(t, fake, config, []string{"HOME=" + t.TempDir()}, args...)
+		if err == nil {
+			t.Fatalf("gocloud make (keep=%v) should fail when configuring the node fails:\n%s", keep, out)
+		}
+		if !strings.Contains(out, "cloud-init: bootcmd failed") {
+			t.Errorf("gocloud make (keep=%v) didn't show the serial console:\n%s", keep, out)
+		}
+		if got := fake.Instance("us-east1-b", "builder"); keep && got == nil {
+			t.Errorf("gocloud make --keep-on-failure deleted the node:\n%s", out)
+		} else if !keep && got != nil {
+			t.Errorf("gocloud make didn't delete the failed node:\n%s", out)
+		}
+	}
+}
+
+func TestMakeCount(t *testing.T) {
+	fake, srv, config := newSshFake(t, "build-1")
+	// With --parallel 1, the node being configured is the newest one.
+	names := []string{"build-3", "build-2", "build-1"}
+	srv.SetMetadata(func(key string) (string, bool) {
+		for _, name := range names {
+			inst := fake.Instance("us-east1-b", name)
+			if inst == nil {
+				continue
+			}
+			for _, it := range inst.Metadata.Items {
+				if it.Key == key {
+					return *it.Value, true
+				}
+			}
+			return "", false
+		}
+		return "", false
+	})
+	fake.FailInsert("QUOTA_EXCEEDED", "Quota 'CPUS' exceeded.")
+
+	home := t.TempDir()
+	out, err := gocloudEnv(t, fake, config, []string{"HOME=" + home},
+		"--ssh-port", strconv.Itoa(srv.Port()), "make", "--count", "3", "--parallel", "1", "small", "build-{{.Index}}")
+	if err == nil {
+		t.Fatalf("gocloud make --count should fail when a node fails:\n%s", out)
+	}
+	if !strings.Contains(out, "made 2 of 3 nodes") || !strings.Contains(out, "QUOTA_EXCEEDED") {
+		t.Errorf("gocloud make --count didn't summarize the results:\n%s", out)
+	}
+	if strings.Contains(out, "deleting build-1") {
+		t.Errorf("gocloud make --count cleaned up build-1, which it never made:\n%s", out)
+	}
+
+	sshconfig, err := os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		t.Fatalf("gocloud make didn't write an ssh config: %v", err)
+	}
+	for _, name := range []string{"build-2", "build-3"} {
+		if fake.Instance("us-east1-b", name) == nil {
+			t.Errorf("gocloud make --count didn't make %s", name)
+		}
+		if !strings.Contains(string(sshconfig), "Host "+name+"\n") {
+			t.Errorf("ssh config has no alias for %s:\n%s", name, sshconfig)
+		}
+	}
+	if strings.Contains(string(sshconfig), "Host build-1\n") {
+		t.Errorf("ssh config has an alias for the failed build-1:\n%s", sshconfig)
+	}
+	if tokens := metadataValues(fake, "instancetoken", "build-2", "build-3"); tokens[0] == "" || tokens[0] == tokens[1] {
+		t.Error("gocloud make --count gave the nodes the same instancetoken")
+	}
+}
+
+// metadataValues returns the value of the metadata attribute key of each
+// of the named instances.
+func metadataValues(fake *fakecompute.Server, key string, names ...string) []string {
+	values := make([]string, len(names))
+	for i, name := range names {
+		inst := fake.Instance("us-east1-b", name)
+		if inst == nil {
+			continue
+		}
+		for _, it := range inst.Metadata.Items {
+			if it.Key == key {
+				values[i] = *it.Value
+			}
+		}
+	}
+	return values
+}
+
+func TestMakeExisting(t *testing.T) {
+	fake, srv, config := newSshFake(t, "builder")
+	home := t.TempDir()
+	run := func(input string, args ...string) (string, error) {
+		t.Helper()
+		args = append([]string{"--ssh-port", strconv.Itoa(srv.Port()), "make"}, args...)
+		return gocloudInput(t, fake, config, []string{"HOME=" + home}, input, append(args, "small", "builder")...)
+	}
+	if out, err := run(""); err != nil {
+		t.Fatalf("gocloud make failed: %v\n%s", err, out)
+	}
+	token := metadataValues(fake, "instancetoken", "builder")[0]
+
+	out, err := run("")
+	if err == nil {
+		t.Fatalf("gocloud make of an existing node should fail without adopting it:\n%s", out)
+	}
+	if !strings.Contains(out, "builder already exists: adopt it, remake it with --replace") {
+		t.Errorf("gocloud make didn't explain the failure:\n%s", out)
+	}
+
+	os.Remove(filepath.Join(home, ".ssh", "config"))
+	if out, err := run("y\n"); err != nil {
+		t.Fatalf("gocloud make failed to adopt builder: %v\n%s", err, out)
+	}
+	if got := metadataValues(fake, "instancetoken", "builder")[0]; got != token {
+		t.Error("adopting builder remade it")
+	}
+	sshconfig, err := os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil || !strings.Contains(string(sshconfig), "Host builder\n") {
+		t.Errorf("adopting builder didn't refresh its ssh alias: %v\n%s", err, sshconfig)
+	}
+
+	if out, err := run("", "--replace"); err != nil {
+		t.Fatalf("gocloud make --replace failed: %v\n%s", err, out)
+	}
+	if got := metadataValues(fake, "instancetoken", "builder")[0]; got == "" || got == token {
+		t.Error("gocloud make --replace didn't remake builder")
+	}
+
+	// A node made from another configuration can only be replaced.
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name:        "other",
+		MachineType: "https://www.googleapis.com/compute/v1/projects/testproject/zones/us-east1-b/machineTypes/e2-small",
+	})
+	out, err = gocloudInput(t, fake, config, []string{"HOME=" + home}, "y\n", "make", "small", "other")
+	if err == nil || !strings.Contains(out, "other already exists but it wasn't made by gocloud") {
+		t.Errorf("gocloud make of a foreign node got %v:\n%s", err, out)
+	}
+	out, err = gocloudEnv(t, fake, config, []string{"HOME=" + home}, "make", "--replace", "small", "other")
+	if err == nil || !strings.Contains(out, "gocloud won't replace it") {
+		t.Errorf("gocloud make --replace of a foreign node got %v:\n%s", err, out)
+	}
+	if fake.Instance("us-east1-b", "other") == nil {
+		t.Error("gocloud make --replace deleted a foreign node")
+	}
+}
+
+func TestStopStart(t *testing.T) {
+	fake, srv, config := newSshFake(t, "sleeper")
+	home := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		args = append([]string{"--ssh-port", strconv.Itoa(srv.Port())}, args...)
+		out, err := gocloudEnv(t, fake, config, []string{"HOME=" + home}, args...)
+		if err != nil {
+			t.Fatalf("gocloud %s failed: %v\n%s", args[2], err, out)
+		}
+	}
+
+	run("make", "small", "sleeper")
+	run("stop", "sleeper")
+	if got, want := fake.Instance("us-east1-b", "sleeper").Status, "TERMINATED"; got != want {
+		t.Errorf("gocloud stop left status %s, want %s", got, want)
+	}
+
+	// start must put back the ssh alias for the node's new address.
+	sshconfigpath := filepath.Join(home, ".ssh", "config")
+	if err := os.Remove(sshconfigpath); err != nil {
+		t.Fatalf("can't remove ssh config: %v", err)
+	}
+	ncommands := len(srv.Commands())
+
+	run("start", "sleeper")
+	if got, want := fake.Instance("us-east1-b", "sleeper").Status, "RUNNING"; got != want {
+		t.Errorf("gocloud start left status %s, want %s", got, want)
+	}
+	if got := srv.Commands()[ncommands:]; len(got) != 0 {
+		t.Errorf("gocloud start ran unexpected commands %q", got)
+	}
+	sshconfig, err := os.ReadFile(sshconfigpath)
+	if err != nil {
+		t.Fatalf("gocloud start didn't write an ssh config: %v", err)
+	}
+	if !strings.Contains(string(sshconfig), "Host sleeper\n\tHostName 127.0.0.1\n") {
+		t.Errorf("ssh config has no alias for sleeper:\n%s", sshconfig)
+	}
+
+	run("suspend", "sleeper")
+	run("resume", "sleeper")
+	run("reset", "sleeper")
+
+}
+
+func TestAttachDetachDisk(t *testing.T) {
+	fake := newFake(t)
+
+	if out, err := gocloud(t, fake, "attach-disk", "worker", "data"); err == nil {
+		t.Errorf("gocloud attach-disk of a missing disk succeeded:\n%s", out)
+	}
+	out, err := gocloud(t, fake, "attach-disk", "--size", "100", "--type", "pd-ssd", "worker", "data")
+	if err != nil {
+		t.Fatalf("gocloud attach-disk failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "attachDisk of worker in us-east1-b: done") {
+		t.Errorf("gocloud attach-disk didn't report progress:\n%s", out)
+	}
+	if disks := fake.Instance("us-east1-b", "worker").Disks; len(disks) != 1 || disks[0].DeviceName != "data" {
+		t.Errorf("gocloud attach-disk left disks %+v, want data", disks)
+	}
+
+	if out, err := gocloud(t, fake, "detach-disk", "worker", "data"); err != nil {
+		t.Fatalf("gocloud detach-disk failed: %v\n%s", err, out)
+	}
+	if disks := fake.Instance("us-east1-b", "worker").Disks; len(disks) != 0 {
+		t.Errorf("gocloud detach-disk left disks %+v", disks)
+	}
+	if fake.Disk("us-east1-b", "data") == nil {
+		t.Error("gocloud detach-disk deleted the disk")
+	}
+}
+
+func TestDelFindsZone(t *testing.T) {
+	fake := fakecompute.NewServer("testproject", "us-east1-b", "us-west1-a")
+	t.Cleanup(fake.Close)
+	fake.AddInstance("us-west1-a", &compute.Instance{Name: "westerly"})
+
+	if out, err := gocloud(t, fake, "describe", "--zone", "us-east1-b", "westerly"); err == nil {
+		t.Errorf("gocloud describe in the wrong zone should fail:\n%s", out)
+	}
+
+	out, err := gocloud(t, fake, "del", "westerly")
+	if err != nil {
+		t.Fatalf("gocloud del failed: %v\n%s", err, out)
+	}
+	if fake.Instance("us-west1-a", "westerly") != nil {
+		t.Error("gocloud del didn't delete the instance")
+	}
+}
+
+func TestSyncSsh(t *testing.T) {
+	fake := newFake(t)
+	token := "secret-token"
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name:        "builder",
+		MachineType: "https://www.googleapis.com/compute/v1/projects/testproject/zones/us-east1-b/machineTypes/e2-small",
+		Metadata:    &compute.Metadata{Items: []*compute.MetadataItems{{Key: "instancetoken", Value: &token}}},
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{AccessConfigs: []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT", NatIP: "192.0.2.8"}}},
+		},
+	})
+
+	// builder has a new address, gone was deleted, worker wasn't made
+	// by gocloud, elsewhere is in another project and legacy, which
+	// doesn't record its project, is for a node that is gone too.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, alias := range []struct{ project, name, ip string }{
+		{"testproject", "builder", "192.0.2.1"},
+		{"testproject", "gone", "192.0.2.2"},
+		{"testproject", "worker", "192.0.2.7"},
+		{"otherproject", "elsewhere", "192.0.2.3"},
+		{"", "legacy", "192.0.2.4"},
+	} {
+		if err := config.AddSshAlias(alias.project, alias.name, alias.ip, "", nil); err != nil {
+			t.Fatalf("AddSshAlias: %v", err)
+		}
+	}
+	sshconfig := filepath.Join(home, ".ssh", "config")
+	before, err := os.ReadFile(sshconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := gocloudEnv(t, fake, testconfig, []string{"HOME=" + home}, "sync-ssh", "--dry-run")
+	if err != nil {
+		t.Fatalf("gocloud sync-ssh --dry-run failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{"update builder:", "-\tHostName 192.0.2.1\n+\tHostName 192.0.2.8\n", "remove gone:", "remove legacy:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("gocloud sync-ssh --dry-run output doesn't contain %q:\n%s", want, out)
+		}
+	}
+	for _, name := range []string{"worker", "elsewhere"} {
+		if strings.Contains(out, name) {
+			t.Errorf("gocloud sync-ssh --dry-run changes %s:\n%s", name, out)
+		}
+	}
+	if after, err := os.ReadFile(sshconfig); err != nil || string(after) != string(before) {
+		t.Errorf("gocloud sync-ssh --dry-run changed ~/.ssh/config: %v", err)
+	}
+
+	if out, err := gocloudEnv(t, fake, testconfig, []string{"HOME=" + home}, "sync-ssh"); err != nil {
+		t.Fatalf("gocloud sync-ssh failed: %v\n%s", err, out)
+	}
+	after, err := os.ReadFile(sshconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(after), "Host builder\n\tHostName 192.0.2.8\n") {
+		t.Errorf("gocloud sync-ssh didn't update builder:\n%s", after)
+	}
+	for _, name := range []string{"gone", "legacy"} {
+		if strings.Contains(string(after), name) {
+			t.Errorf("gocloud sync-ssh didn't remove %s:\n%s", name, after)
+		}
+	}
+	for _, name := range []string{"worker", "elsewhere"} {
+		if !strings.Contains(string(after), "Host "+name+"\n") {
+			t.Errorf("gocloud sync-ssh removed %s:\n%s", name, after)
+		}
+	}
+
+	out, err = gocloudEnv(t, fake, testconfig, []string{"HOME=" + home}, "sync-ssh")
+	if err != nil || !strings.Contains(out, "up to date") {
+		t.Errorf("gocloud sync-ssh after syncing got %v:\n%s", err, out)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	fake := newFake(t)
+
+	out, err := gocloud(t, fake, "check-config")
+	if err != nil {
+		t.Fatalf("gocloud check-config failed: %v\n%s", err, out)
+	}
+	if !strings.HasSuffix(out, "is ok\n") {
+		t.Errorf("gocloud check-config got %q, want it to be ok", out)
+	}
+
+	out, err = gocloudEnv(t, fake, testconfig+"\tzone = \"nowhere\"\n", []string{"HOME=" + t.TempDir()}, "check-config")
+	if err == nil {
+		t.Fatalf("gocloud check-config of a bad zone should fail:\n%s", out)
+	}
+	if !strings.Contains(out, "gocloud.toml:10: instance small: zone \"nowhere\" is not a zone") {
+		t.Errorf("gocloud check-config didn't report the bad zone's position:\n%s", out)
+	}
+
+	// Only the commands that use every setting fail. Others warn.
+	if out, err := gocloudEnv(t, fake, testconfig+"\tzone = \"nowhere\"\n", []string{"HOME=" + t.TempDir()}, "make", "small", "worker"); err == nil {
+		t.Errorf("gocloud make with a bad config should fail:\n%s", out)
+	}
+	out, err = gocloudEnv(t, fake, testconfig+"\tzone = \"nowhere\"\n", []string{"HOME=" + t.TempDir()}, "ls")
+	if err != nil {
+		t.Errorf("gocloud ls with a bad config should only warn: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "warning: ") || !strings.Contains(out, "zone \"nowhere\" is not a zone") {
+		t.Errorf("gocloud ls didn't warn about the bad zone:\n%s", out)
+	}
+
+	ghost := testconfig + "\tserviceaccount = \"ghost@testproject.iam.gserviceaccount.com\"\n"
+	out, err = gocloudEnv(t, fake, ghost, []string{"HOME=" + t.TempDir()}, "check-config")
+	if err == nil {
+		t.Fatalf("gocloud check-config of a missing service account should fail:\n%s", out)
+	}
+	if !strings.Contains(out, "gocloud.toml:10: instance small: service account ghost@testproject.iam.gserviceaccount.com doesn't exist") {
+		t.Errorf("gocloud check-config didn't report the missing service account:\n%s", out)
+	}
+	if out, err := gocloudEnv(t, fake, ghost, []string{"HOME=" + t.TempDir()}, "check-config", "--offline"); err != nil {
+		t.Errorf("gocloud check-config --offline shouldn't check service accounts: %v\n%s", err, out)
+	}
+}
+
+func TestShowMeta(t *testing.T) {
+	fake := newFake(t)
+	keyfile := filepath.Join(t.TempDir(), "id_test.pub")
+	if err := os.WriteFile(keyfile, []byte("ssh-ed25519 AAAA test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf("sshpublickey = %q\n", keyfile) + testconfig
+
+	for _, showsecrets := range []bool{false, true} {
+		args := []string{"--format", "json", "show-meta", "small"}
+		if showsecrets {
+			args = append(args, "--show-secrets")
+		}
+		out, err := gocloudStdout(t, fake, config, []string{"HOME=" + t.TempDir()}, args...)
+		if err != nil {
+			t.Fatalf("gocloud show-meta failed: %v\n%s", err, out)
+		}
+		var metadata map[string]string
+		if err := json.Unmarshal([]byte(out), &metadata); err != nil {
+			t.Fatalf("gocloud --format json show-meta isn't json: %v\n%s", err, out)
+		}
+		if got, want := metadata["gocloudconfig"], "small"; got != want {
+			t.Errorf("gocloudconfig got %q, want %q", got, want)
+		}
+		for _, k := range []string{"instancetoken", "user-data"} {
+			if got := metadata[k]; showsecrets == (got == "<redacted>") {
+				t.Errorf("show-meta (show-secrets=%v) gave %s = %q", showsecrets, k, got)
+			}
+		}
+	}
+}
+
+func TestShowConfig(t *testing.T) {
+	fake := newFake(t)
+	config := testconfig + `
+[instance.big]
+	extends = "small"
+	hardware = "n2-standard-8"
+`
+	out, err := gocloudEnv(t, fake, config, []string{"HOME=" + t.TempDir()}, "show-config", "big")
+	if err != nil {
+		t.Fatalf("gocloud show-config failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{`family = "cos-cloud"`, `hardware = "n2-standard-8"`, `zone = "us-east1-b"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("gocloud show-config output doesn't include %s:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/rjkroege/gocloud/config"
+	"github.com/rjkroege/gocloud/gcp"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// makeResult is the outcome of making one node.
+type makeResult struct {
+	name string
+	ni   *gcp.NodeInfo
+	err  error
+}
+
+// makeNodes makes, waits for and configures the nodes called names from
+// the instance configuration configName, at most parallel at a time. A
+// node that fails after it was inserted is cleaned up, or kept when keep
+// is set, without affecting the others. It gives up on the nodes not yet
+// made when ctx is done. The results are in the order of names.
+func makeNodes(ctx context.Context, client *gcp.Client, settings *config.Settings, configName string, names []string, parallel int, keep bool) []makeResult {
+	results := make([]makeResult, len(names))
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for i := range names {
+			next <- i
+		}
+	}()
+
+	// ~/.ssh/config and the output are shared by the nodes.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < parallel && w < len(names); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				name := names[i]
+				if err := ctx.Err(); err != nil {
+					results[i] = makeResult{name: name, err: fmt.Errorf("not made: %v", err)}
+					continue
+				}
+				ni, err := makeAndConfigure(ctx, client, settings, configName, name)
+				if err != nil {
+					// Clean up with the client's context that the
+					// interrupt didn't cancel.
+					var buf bytes.Buffer
+					fmt.Fprintf(&buf, "can't make %s: %v\n", name, err)
+					var notmade *gcp.NotMadeError
+					if !errors.As(err, &notmade) {
+						if err := client.CleanUpFailedNode(&buf, configName, name, keep); err != nil {
+							fmt.Fprintf(&buf, "can't clean up %s: %v\n", name, err)
+						}
+					}
+					mu.Lock()
+					os.Stdout.Write(buf.Bytes())
+					mu.Unlock()
+					results[i] = makeResult{name: name, err: err}
+					continue
+				}
+
+				mu.Lock()
+				if err := config.AddSshAlias(client.ProjectId, ni.Name, ni.Addr, ni.JumpHost, ni.HostKey); err != nil {
+					fmt.Printf("can't update ssh for node %v: %v\n", ni.Name, err)
+				}
+				mu.Unlock()
+				results[i] = makeResult{name: name, ni: ni}
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// existingNodes deals with the nodes in names that already exist before
+// gocloud make does any work. With replace, it deletes them, and the
+// data disks that configName doesn't reuse, but refuses nodes that
+// gocloud didn't make. Otherwise, it offers to adopt each one that
+// matches the instance configuration configName, reading the answers
+// from in, and refreshes its ssh alias. It returns the names of the
+// nodes still to make.
+func existingNodes(client *gcp.Client, settings *config.Settings, configName string, names []string, replace bool, in io.Reader) ([]string, error) {
+	existing := make(map[string]*compute.Instance)
+	for _, name := range names {
+		inst, mismatch, err := client.ExistingNode(configName, name)
+		if err != nil {
+			return nil, err
+		}
+		if inst == nil {
+			continue
+		}
+		if replace && !gcp.MadeByGocloud(inst) {
+			return nil, fmt.Errorf("%s already exists but it wasn't made by gocloud: gocloud won't replace it", name)
+		}
+		if mismatch != "" && !replace {
+			return nil, fmt.Errorf("%s already exists but %s: remake it with --replace or choose another name", name, mismatch)
+		}
+		existing[name] = inst
+	}
+	if len(existing) == 0 {
+		return names, nil
+	}
+
+	if !replace {
+		answers := bufio.NewReader(in)
+		for _, name := range names {
+			if existing[name] == nil {
+				continue
+			}
+			fmt.Printf("%s already exists with configuration %s. Adopt it and refresh its ssh alias? [y/N] ", name, configName)
+			answer, _ := answers.ReadString('\n')
+			if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+				fmt.Println()
+				return nil, fmt.Errorf("%s already exists: adopt it, remake it with --replace or choose another name", name)
+			}
+		}
+	}
+
+	tomake := make([]string, 0, len(names))
+	for _, name := range names {
+		inst := existing[name]
+		switch {
+		case inst == nil:
+			tomake = append(tomake, name)
+		case replace:
+			if err := client.ReplaceNode(os.Stdout, inst, configName); err != nil {
+				return nil, fmt.Errorf("can't delete %s to replace it: %v", name, err)
+			}
+			tomake = append(tomake, name)
+		default:
+			ni, err := client.AdoptNode(inst)
+			if err != nil {
+				return nil, fmt.Errorf("can't adopt %s: %v", name, err)
+			}
+			if err := reconnect(settings, ni); err != nil {
+				return nil, fmt.Errorf("can't adopt %s: %v", name, err)
+			}
+			fmt.Println("adopted", name)
+		}
+	}
+	return tomake, nil
+}
+
+// makeAndConfigure makes the node called name from the instance
+// configuration configName, waits for its ssh server and configures it.
+// It gives up when ctx is done.
+func makeAndConfigure(ctx context.Context, client *gcp.Client, settings *config.Settings, configName, name string) (*gcp.NodeInfo, error) {
+	ni, err := client.WithContext(ctx).MakeNode(configName, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for the Ssh server to be running.
+	sshclient, err := gcp.WaitForSshContext(ctx, settings, ni)
+	if err != nil {
+		return nil, fmt.Errorf("no ssh ever came up: %v", err)
+	}
+	defer sshclient.Close()
+
+	// Closing the connection interrupts the configuration.
+	defer context.AfterFunc(ctx, func() { sshclient.Close() })()
+	if err := gcp.ConfigureViaSsh(settings, ni, sshclient); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("ConfigureViaSsh failed: %v", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ni, nil
+}
+
+// printMakeResults prints a summary of results to w.
+func printMakeResults(w io.Writer, results []makeResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDRESS\tRESULT")
+	made := 0
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(tw, "%s\t\tfailed: %v\n", r.name, r.err)
+			continue
+		}
+		made++
+		fmt.Fprintf(tw, "%s\t%s\tmade\n", r.name, r.ni.Addr)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "made %d of %d nodes\n", made, len(results))
+}
@@ -66,7 +66,7 @@ func main() {
 					continue
 				}
 
-				if err := gcp.EndSession(&config.Settings{}, ""); err != nil {
+				if err := gcp.EndSession(&config.Settings{}, "", ""); err != nil {
 					log.Println("failed to EndSession:", err)
 				}
 			}
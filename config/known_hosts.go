@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// pinHostKey makes hostkey the only key for name in the known_hosts
+// file hostsfile.
+func pinHostKey(hostsfile string, name string, hostkey ssh.PublicKey) error {
+	if err := removeKnownHosts(hostsfile, []string{name}); err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(hostsfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("can't open %q: %v", hostsfile, err)
+	}
+	if _, err := fmt.Fprintln(fd, knownhosts.Line([]string{name}, hostkey)); err != nil {
+		fd.Close()
+		return fmt.Errorf("can't write %q: %v", hostsfile, err)
+	}
+	return fd.Close()
+}
+
+// removeKnownHosts removes the host patterns that name one of hosts,
+// including hashed entries, from the known_hosts file knownhosts. A line
+// whose every pattern is removed goes too. It is not an error for
+// knownhosts to not exist.
+func removeKnownHosts(knownhosts string, hosts []string) error {
+	filebuffer, err := ioutil.ReadFile(knownhosts)
+	if err != nil {
+		// No known_hosts is not an error.
+		return nil
+	}
+
+	lines := bytes.SplitAfter(filebuffer, []byte("\n"))
+	kept := make([][]byte, 0, len(lines))
+	changed := false
+	for _, l := range lines {
+		nl, ok := removeKnownHostsPatterns(string(l), hosts)
+		if !ok {
+			kept = append(kept, l)
+			continue
+		}
+		changed = true
+		if nl != "" {
+			kept = append(kept, []byte(nl))
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	tmpfilename := knownhosts + ".tmp"
+	if err := os.WriteFile(tmpfilename, bytes.Join(kept, nil), 0600); err != nil {
+		os.Remove(tmpfilename)
+		return fmt.Errorf("can't write tmp %q: %v", tmpfilename, err)
+	}
+	return SafeReplaceFile(tmpfilename, knownhosts)
+}
+
+// removeKnownHostsPatterns removes the host patterns that name one of
+// hosts from the known_hosts line. It returns the line without them,
+// which is empty when no pattern is left, and whether it removed any.
+func removeKnownHostsPatterns(line string, hosts []string) (string, bool) {
+	f := strings.Fields(line)
+	if len(f) > 0 && strings.HasPrefix(f[0], "@") {
+		// Skip the @cert-authority or @revoked marker.
+		f = f[1:]
+	}
+	if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+		return line, false
+	}
+
+	patterns := strings.Split(f[0], ",")
+	kept := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if !knownHostsPatternMatches(pattern, hosts) {
+			kept = append(kept, pattern)
+		}
+	}
+	if len(kept) == len(patterns) {
+		return line, false
+	}
+	if len(kept) == 0 {
+		return "", true
+	}
+	i := strings.Index(line, f[0])
+	return line[:i] + strings.Join(kept, ",") + line[i+len(f[0]):], true
+}
+
+// knownHostsPatternMatches returns true if the known_hosts host pattern
+// names one of hosts.
+func knownHostsPatternMatches(pattern string, hosts []string) bool {
+	for _, h := range hosts {
+		if strings.HasPrefix(pattern, "|1|") {
+			if hashedHostMatches(pattern, h) {
+				return true
+			}
+			continue
+		}
+		if pattern == h || strings.HasPrefix(pattern, "["+h+"]:") {
+			return true
+		}
+	}
+	return false
+}
+
+// hashedHostMatches returns true if the hashed known_hosts host name
+// pattern (|1|salt|hash) is a hash of host.
+func hashedHostMatches(pattern, host string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
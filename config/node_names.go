@@ -0,0 +1,77 @@
+package config
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// randomNameLength is the length of the {{.Random}} part of node names.
+const randomNameLength = 5
+
+// randomNameChars are the characters of the {{.Random}} part of node
+// names. Node names can't hold upper case letters.
+const randomNameChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// randomNameLetters is the number of letters at the start of
+// randomNameChars.
+const randomNameLetters = 26
+
+// NodeNames returns the names of count nodes made from the name template
+// name. The template can use {{.Index}}, counting from 1, and
+// {{.Random}}, a random string that differs for each node. A name
+// without either is used as is when count is 1.
+func NodeNames(name string, count int) ([]string, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("can't make %d nodes", count)
+	}
+	tmpl, err := template.New("node").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return nil, fmt.Errorf("bad node name %q: %v", name, err)
+	}
+
+	names := make([]string, 0, count)
+	seen := make(map[string]bool, count)
+	for i := 1; i <= count; i++ {
+		random, err := randomName()
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, struct {
+			Index  int
+			Random string
+		}{i, random}); err != nil {
+			return nil, fmt.Errorf("bad node name %q: %v", name, err)
+		}
+		n := sb.String()
+		if !nameRegexp.MatchString(n) {
+			return nil, fmt.Errorf("node name %q must be 1-63 lower case letters, digits or dashes, starting with a letter", n)
+		}
+		if seen[n] {
+			return nil, fmt.Errorf("node name %q makes %s more than once: use {{.Index}} or {{.Random}}", name, n)
+		}
+		seen[n] = true
+		names = append(names, n)
+	}
+	return names, nil
+}
+
+// randomName returns a random string for the {{.Random}} part of node
+// names. It starts with a letter, as node names must, so that the name
+// template {{.Random}} by itself makes valid names.
+func randomName() (string, error) {
+	raw := make([]byte, randomNameLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("can't make random name: %v", err)
+	}
+	for i, b := range raw {
+		chars := randomNameChars
+		if i == 0 {
+			chars = randomNameChars[:randomNameLetters]
+		}
+		raw[i] = chars[int(b)%len(chars)]
+	}
+	return string(raw), nil
+}
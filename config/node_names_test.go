@@ -0,0 +1,63 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNodeNames(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		count int
+		want  []string
+		err   string
+	}{
+		{name: "builder", count: 1, want: []string{"builder"}},
+		{name: "build-{{.Index}}", count: 3, want: []string{"build-1", "build-2", "build-3"}},
+		{name: "builder", count: 2, err: `node name "builder" makes builder more than once`},
+		{name: "Build-{{.Index}}", count: 1, err: `node name "Build-1" must be`},
+		{name: "build-{{.Node}}", count: 1, err: `bad node name`},
+		{name: "build-{{.Index", count: 1, err: `bad node name`},
+		{name: "builder", count: 0, err: `can't make 0 nodes`},
+	} {
+		got, err := NodeNames(tc.name, tc.count)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("NodeNames(%q, %d) got error %v, want %q", tc.name, tc.count, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NodeNames(%q, %d): %v", tc.name, tc.count, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("NodeNames(%q, %d) mismatch (-want +got):\n%s", tc.name, tc.count, diff)
+		}
+	}
+}
+
+func TestNodeNamesRandom(t *testing.T) {
+	names, err := NodeNames("dev-{{.Random}}", 10)
+	if err != nil {
+		t.Fatalf("NodeNames: %v", err)
+	}
+	for _, n := range names {
+		if !nameRegexp.MatchString(n) || len(n) != len("dev-")+randomNameLength {
+			t.Errorf("NodeNames made bad random name %q", n)
+		}
+	}
+
+	// Enough names that some would start with a digit if they could.
+	names, err = NodeNames("{{.Random}}", 200)
+	if err != nil {
+		t.Fatalf("NodeNames: %v", err)
+	}
+	for _, n := range names {
+		if !nameRegexp.MatchString(n) {
+			t.Errorf("NodeNames made bad random name %q", n)
+		}
+	}
+}
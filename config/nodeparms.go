@@ -28,7 +28,7 @@ func addNodeMetadatav2(client *http.Client, nm NodeMetadata) {
 		"githost",
 	}); err != nil {
 		nm["githost"] = "https://git.liqui.org/rjkroege/scripts.git"
-		log.Println("set githost to %q", nm["githost"])
+		log.Printf("set githost to %q", nm["githost"])
 	}
 }
 
@@ -52,11 +52,16 @@ func addNodeMetadatav1(client *http.Client) (NodeMetadata, error) {
 	if err := addNodeMetadataImpl(client, nm, []string{
 		"username",
 		"sshkey",
-		"rcloneconfig",
 		"instancetoken",
 	}); err != nil {
 		return nil, err
 	}
+
+	// gocloud only sets rcloneconfig when there is a local rclone
+	// configuration so its absence is not an error.
+	addNodeMetadataImpl(client, nm, []string{
+		"rcloneconfig",
+	})
 	return nm, nil
 }
 
@@ -1,14 +1,26 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
 
 	"github.com/BurntSushi/toml"
 )
 
 type InstanceConfig struct {
+	// Extends names another instance configuration. Settings that this
+	// one leaves out come from it. A setting given here, even as false,
+	// 0, "" or an empty list, overrides the inherited one.
+	Extends string `toml:"extends,omitempty"`
+
 	Family        string `toml:"family"`
 	Hardware      string `toml:"hardware"`
 	DiskSize      int64  `toml:"disksize,omitempty"`
@@ -17,6 +29,159 @@ type InstanceConfig struct {
 	PostSshConfig string `toml:"postsshconfig,omitempty"`
 	GitHost       string `toml:"githost,omitempty"`
 	UserData      string `toml:"userdata,omitempty"`
+
+	// UserDataFile is a file holding the user data. A relative path is
+	// relative to the directory holding the configuration file.
+	UserDataFile string `toml:"userdatafile,omitempty"`
+
+	// ImageProject is the project holding the boot image. The default is
+	// Family, which older configurations use to name the image project,
+	// or the node's own project if Family isn't set either.
+	ImageProject string `toml:"imageproject,omitempty"`
+
+	// ImageFamily is the image family in ImageProject whose newest image
+	// new nodes boot. Without ImageFamily or Image, gocloud picks the
+	// newest stable image from cos-cloud, the only project whose images
+	// it knows how to choose between.
+	ImageFamily string `toml:"imagefamily,omitempty"`
+
+	// Image pins the boot image. It is the name of an image in
+	// ImageProject or a projects/<project>/global/images/<name> path.
+	Image string `toml:"image,omitempty"`
+
+	// Channel is the release channel of the cos-cloud image that gocloud
+	// picks when neither ImageFamily nor Image is set: stable (the
+	// default), beta, dev, lts for the newest long-term support
+	// milestone or lts-<milestone>, e.g. lts-105.
+	Channel string `toml:"channel,omitempty"`
+
+	// DiskType is the type of the boot disk: pd-standard, pd-balanced,
+	// pd-ssd, pd-extreme or a hyperdisk type. Empty means the Compute
+	// Engine default.
+	DiskType string `toml:"disktype,omitempty"`
+
+	// DiskIops is the provisioned IOPS of a pd-extreme or hyperdisk boot
+	// disk.
+	DiskIops int64 `toml:"diskiops,omitzero"`
+
+	// DiskThroughput is the provisioned throughput in MB/s of a
+	// hyperdisk-balanced or hyperdisk-throughput boot disk.
+	DiskThroughput int64 `toml:"diskthroughput,omitzero"`
+
+	// LocalSsds is the number of local NVMe SSDs to attach. Their
+	// contents are lost when the node stops. gocloud adds bootcmd
+	// entries to the user data that format them and mount them,
+	// striped together, at LocalSsdMount.
+	LocalSsds int `toml:"localssds,omitzero"`
+
+	// LocalSsdMount is where the local SSDs are mounted. The default is
+	// /mnt/disks/localssd.
+	LocalSsdMount string `toml:"localssdmount,omitempty"`
+
+	// Network is the VPC network of the node. The default is the
+	// project's default network.
+	Network string `toml:"network,omitempty"`
+
+	// Subnetwork is the subnetwork of Network in the node's region.
+	Subnetwork string `toml:"subnetwork,omitempty"`
+
+	// Tags are the node's network tags. They select the firewall rules
+	// that apply to it.
+	Tags []string `toml:"tags,omitempty"`
+
+	// ExternalIp is the node's external address: ephemeral (the
+	// default), static or none. gocloud reserves a static address when it
+	// makes the node and releases it when it deletes the node. Without an
+	// external address, gocloud reaches the node at its internal address
+	// through JumpHost.
+	ExternalIp string `toml:"externalip,omitempty"`
+
+	// JumpHost is the ssh jump host, [user@]host[:port], for a node
+	// without an external address.
+	JumpHost string `toml:"jumphost,omitempty"`
+
+	// HostKey delivers a new ssh host key to the node in the
+	// HostKeyMetadataKey metadata attribute and pins it in the local ssh
+	// configuration. The node's user data must install it. Without
+	// HostKey, only the instance token check shows that gocloud reached
+	// the right node.
+	HostKey bool `toml:"hostkey,omitempty"`
+
+	// ServiceAccount is the email address of the service account that the
+	// node runs as, default for the project's Compute Engine default
+	// service account (the default) or none for no service account.
+	ServiceAccount string `toml:"serviceaccount,omitempty"`
+
+	// Scopes are the OAuth scopes of the node's service account, as URLs
+	// or as names like devstorage.read_only. The default is the scopes
+	// that Compute Engine gives nodes by default, which include neither
+	// compute nor cloud-platform.
+	Scopes []string `toml:"scopes,omitempty"`
+
+	// Labels are added to the labels that gocloud gives the node. Keys
+	// that start with gocloud- are reserved for gocloud's own labels.
+	Labels map[string]string `toml:"labels,omitempty"`
+
+	// Provisioning is the provisioning model: standard (the default),
+	// spot or preemptible. Spot and preemptible nodes cost less but
+	// Compute Engine can reclaim them at any time.
+	Provisioning string `toml:"provisioning,omitempty"`
+
+	// TerminationAction is what happens to a node when it is preempted
+	// or reaches its MaxRunDuration: stop or delete.
+	TerminationAction string `toml:"terminationaction,omitempty"`
+
+	// MaxRunDuration limits how long a node runs, e.g. "4h".
+	MaxRunDuration string `toml:"maxrunduration,omitempty"`
+
+	// AutomaticRestart restarts a node that Compute Engine stops for
+	// maintenance or failure. It can't be set for spot or preemptible
+	// nodes.
+	AutomaticRestart *bool `toml:"automaticrestart,omitempty"`
+
+	// Disks are persistent disks attached to the node in addition to its
+	// boot disk. They are written as [[instance.<name>.disk]] tables.
+	Disks []DiskConfig `toml:"disk,omitempty"`
+}
+
+// DiskConfig is a persistent data disk for a node.
+type DiskConfig struct {
+	// Name is a text/template for the disk's name. It can refer to .Node,
+	// the node's name, and .Config, the instance configuration's name.
+	// The node sees the disk as /dev/disk/by-id/google-<name>.
+	Name string `toml:"name"`
+
+	// Size is the size in GB of a new disk.
+	Size int64 `toml:"size,omitempty"`
+
+	// Type is the disk type of a new disk, e.g. pd-ssd. The default is
+	// pd-balanced.
+	Type string `toml:"type,omitempty"`
+
+	// Mode is rw (the default) or ro.
+	Mode string `toml:"mode,omitempty"`
+
+	// AutoDelete deletes the disk with the node. By default, the disk
+	// outlives the node so that its data survives gocloud del.
+	AutoDelete bool `toml:"autodelete,omitempty"`
+
+	// Reuse attaches the existing disk called Name if there is one
+	// instead of failing to make the node.
+	Reuse bool `toml:"reuse,omitempty"`
+}
+
+// DiskName returns the name of the disk d for the node called node made
+// from the instance configuration configName.
+func (d DiskConfig) DiskName(node, configName string) (string, error) {
+	tmpl, err := template.New("disk").Option("missingkey=error").Parse(d.Name)
+	if err != nil {
+		return "", fmt.Errorf("bad disk name %q: %v", d.Name, err)
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, struct{ Node, Config string }{node, configName}); err != nil {
+		return "", fmt.Errorf("bad disk name %q: %v", d.Name, err)
+	}
+	return sb.String(), nil
 }
 
 type Settings struct {
@@ -27,54 +192,219 @@ type Settings struct {
 	SshPrivateKeyFile string                    `toml:"sshprivatekey,omitempty"`
 	Credential        string                    `toml:"credential,omitempty"`
 	DefaultUserData   string                    `toml:"defaultuserdata,omitempty"`
+
+	// DefaultUserDataFile is like InstanceConfig.UserDataFile for
+	// instances without their own user data.
+	DefaultUserDataFile string `toml:"defaultuserdatafile,omitempty"`
+
+	// Endpoint overrides the Compute Engine API endpoint. It is not read
+	// from the config file. Setting it disables authentication so that
+	// gocloud can be tested against a fake API server. The fake also
+	// serves the IAM API from the root of the same host.
+	Endpoint string `toml:"-"`
+
+	// SshPort overrides the port used to reach new nodes with ssh. Like
+	// Endpoint, it is not read from the config file and exists for
+	// testing.
+	SshPort int `toml:"-"`
+
+	// path is the configuration file and lines the line of each setting
+	// in it, for reporting Problems found after Read.
+	path  string
+	lines map[string]int
+
+	// meta records which settings the file gives so that an instance
+	// configuration can override an inherited setting with its zero
+	// value.
+	meta toml.MetaData
 }
 
+// Read reads and validates the configuration file path. Validation
+// failures are Problems. Read returns the settings along with the
+// Problems of a file that parses so that a caller can decide whether
+// they matter.
 func Read(path string) (*Settings, error) {
-	fd, err := os.Open(path)
+	contents, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("no config file %q: %v", path, err)
 	}
 
-	settings := &Settings{}
-	decoder := toml.NewDecoder(fd)
-	if _, err := decoder.Decode(settings); err != nil {
+	settings := &Settings{path: path, lines: keyLines(contents)}
+	md, err := toml.NewDecoder(bytes.NewReader(contents)).Decode(settings)
+	if err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			return nil, Problems{{File: path, Line: perr.Position.Line, Message: perr.Message}}
+		}
 		return nil, fmt.Errorf("error parsing config %q: %v", path, err)
 	}
 
-	// TODO(rjk): Validate the configurable settings.
+	settings.meta = md
+	settings.resolveUserDataFiles(filepath.Dir(path))
+	if err := settings.validate(path, md); err != nil {
+		return settings, err
+	}
 	return settings, nil
 }
 
+// resolveUserDataFiles makes the user data file paths relative to dir
+// absolute.
+func (s *Settings) resolveUserDataFiles(dir string) {
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	s.DefaultUserDataFile = resolve(s.DefaultUserDataFile)
+	for name, ic := range s.InstanceTypes {
+		ic.UserDataFile = resolve(ic.UserDataFile)
+		s.InstanceTypes[name] = ic
+	}
+}
+
+// Instance returns the instance configuration instancetype with the
+// settings that it inherits through its chain of extends filled in. It
+// is an error for the chain to name a missing configuration or to be a
+// cycle.
+func (s *Settings) Instance(instancetype string) (InstanceConfig, error) {
+	ic, ok := s.InstanceTypes[instancetype]
+	if !ok {
+		return InstanceConfig{}, fmt.Errorf("undefined instance type %q", instancetype)
+	}
+
+	chain := []string{instancetype}
+	for ic.Extends != "" {
+		parent := ic.Extends
+		for _, n := range chain {
+			if n == parent {
+				return InstanceConfig{}, fmt.Errorf("instance %s: extends cycle %s -> %s", instancetype, strings.Join(chain, " -> "), parent)
+			}
+		}
+		base, ok := s.InstanceTypes[parent]
+		if !ok {
+			return InstanceConfig{}, fmt.Errorf("instance %s: extends undefined instance type %q", chain[len(chain)-1], parent)
+		}
+		ic = inherit(ic, base, func(key string) bool {
+			for _, n := range chain {
+				if s.meta.IsDefined("instance", n, key) {
+					return true
+				}
+			}
+			return false
+		})
+		chain = append(chain, parent)
+	}
+	return ic, nil
+}
+
+// inherit returns ic with the settings that it leaves out taken from
+// base. given reports whether ic's configuration file gives the setting
+// key: those are kept even when they are zero. The user data and user
+// data file are a single setting: setting either replaces both.
+func inherit(ic, base InstanceConfig, given func(key string) bool) InstanceConfig {
+	if ic.UserData != "" || ic.UserDataFile != "" || given("userdata") || given("userdatafile") {
+		base.UserData = ic.UserData
+		base.UserDataFile = ic.UserDataFile
+	}
+
+	icv := reflect.ValueOf(&ic).Elem()
+	basev := reflect.ValueOf(base)
+	for i := 0; i < icv.NumField(); i++ {
+		key, _, _ := strings.Cut(icv.Type().Field(i).Tag.Get("toml"), ",")
+		if icv.Field(i).IsZero() && !given(key) {
+			icv.Field(i).Set(basev.Field(i))
+		}
+	}
+	ic.Extends = base.Extends
+	return ic
+}
+
+// WriteInstance writes the instance configuration instancetype to w as
+// TOML, with inherited and default settings filled in.
+func (s *Settings) WriteInstance(w io.Writer, instancetype string) error {
+	ic, err := s.Instance(instancetype)
+	if err != nil {
+		return err
+	}
+	ic.Zone = s.Zone(instancetype)
+	if ic.UserData == "" && ic.UserDataFile == "" {
+		ic.UserData = s.DefaultUserData
+		ic.UserDataFile = s.DefaultUserDataFile
+	}
+
+	return toml.NewEncoder(w).Encode(map[string]map[string]InstanceConfig{
+		"instance": {instancetype: ic},
+	})
+}
+
 // Zone returns the zone for this instancetype.
 func (s *Settings) Zone(instancetype string) string {
-	if z, ok := s.InstanceTypes[instancetype]; ok && z.Zone != "" {
+	if z, err := s.Instance(instancetype); err == nil && z.Zone != "" {
 		return z.Zone
 	}
 	return s.DefaultZone
 }
 
-func (s *Settings) UserData(instancetype string) string {
-	if z, ok := s.InstanceTypes[instancetype]; ok && z.UserData != "" {
-		return z.UserData
+// UserData returns the user data for this instancetype, reading it from
+// a user data file if necessary.
+func (s *Settings) UserData(instancetype string) (string, error) {
+	z, err := s.Instance(instancetype)
+	if err != nil {
+		return "", err
+	}
+	path := s.DefaultUserDataFile
+	switch {
+	case z.UserData != "":
+		return z.UserData, nil
+	case z.UserDataFile != "":
+		path = z.UserDataFile
+	case s.DefaultUserData != "":
+		return s.DefaultUserData, nil
+	}
+	if path == "" {
+		return "", nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("can't read userdata: %v", err)
 	}
-	return s.DefaultUserData
+	return string(b), nil
 }
 
-// UniqueFamilies returns the unique families used in settings.
+// UniqueFamilies returns the unique image projects used in settings.
 func (s *Settings) UniqueFamilies() []string {
 	fm := make(map[string]struct{})
-	for _, v := range s.InstanceTypes {
-		fm[v.Family] = struct{}{}
+	for k := range s.InstanceTypes {
+		if _, err := s.Instance(k); err == nil {
+			fm[s.ImageProject(k)] = struct{}{}
+		}
 	}
 	fa := make([]string, 0)
 	for k := range fm {
 		fa = append(fa, k)
 	}
+	sort.Strings(fa)
 	return fa
 }
 
+// ImageProject returns the project holding the boot images of
+// instancetype. See InstanceConfig.ImageProject.
+func (s *Settings) ImageProject(instancetype string) string {
+	ins, _ := s.Instance(instancetype)
+	switch {
+	case ins.ImageProject != "":
+		return ins.ImageProject
+	case ins.Family != "":
+		return ins.Family
+	}
+	return s.ProjectId
+}
+
 func (s *Settings) Description(instancetype, name string) string {
-	ins := s.InstanceTypes[instancetype]
+	ins, _ := s.Instance(instancetype)
 	if ins.Description != "" {
 		return ins.Description
 	}
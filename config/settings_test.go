@@ -0,0 +1,181 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const extendsconfig = `
+defaultzone = "us-east1-b"
+projectid = "testproject"
+defaultuserdata = "#cloud-config"
+
+[instance.base]
+	family = "cos-cloud"
+	zone = "us-west1-a"
+	userdata = "#cloud-config base"
+	githost = "https://git.example.com/setup.git"
+
+[instance.medium]
+	extends = "base"
+	hardware = "e2-medium"
+	disksize = 50
+
+[instance.big]
+	extends = "medium"
+	hardware = "n2-standard-8"
+	userdatafile = "cloud-config.yaml"
+`
+
+func TestInstanceExtends(t *testing.T) {
+	settings, err := Read(writeConfig(t, extendsconfig))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	got, err := settings.Instance("big")
+	if err != nil {
+		t.Fatalf("Instance: %v", err)
+	}
+	want := InstanceConfig{
+		Family:       "cos-cloud",
+		Hardware:     "n2-standard-8",
+		DiskSize:     50,
+		Zone:         "us-west1-a",
+		GitHost:      "https://git.example.com/setup.git",
+		UserDataFile: got.UserDataFile,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Instance(big) mismatch (-want +got):\n%s", diff)
+	}
+
+	if got, want := settings.Zone("medium"), "us-west1-a"; got != want {
+		t.Errorf("Zone(medium) got %q, want %q", got, want)
+	}
+	for name, want := range map[string]string{
+		"medium": "#cloud-config base",
+		"big":    "#cloud-config\n",
+	} {
+		got, err := settings.UserData(name)
+		if err != nil {
+			t.Fatalf("UserData(%s): %v", name, err)
+		}
+		if got != want {
+			t.Errorf("UserData(%s) got %q, want %q", name, got, want)
+		}
+	}
+
+	if _, err := settings.Instance("missing"); err == nil {
+		t.Error("Instance of an undefined configuration should fail")
+	}
+}
+
+func TestInstanceExtendsOverride(t *testing.T) {
+	settings, err := Read(writeConfig(t, `
+defaultzone = "us-east1-b"
+projectid = "testproject"
+defaultuserdata = "#cloud-config"
+
+[instance.base]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	githost = "https://git.example.com/setup.git"
+	localssds = 2
+	tags = ["ssh"]
+	scopes = ["logging.write"]
+	automaticrestart = true
+
+[instance.plain]
+	extends = "base"
+	githost = ""
+	localssds = 0
+
+[instance.plainer]
+	extends = "plain"
+	tags = []
+	scopes = []
+	automaticrestart = false
+`))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	got, err := settings.Instance("plainer")
+	if err != nil {
+		t.Fatalf("Instance: %v", err)
+	}
+	norestart := false
+	want := InstanceConfig{
+		Family:           "cos-cloud",
+		Hardware:         "e2-small",
+		Tags:             []string{},
+		Scopes:           []string{},
+		AutomaticRestart: &norestart,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Instance(plainer) mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestInstanceExtendsCycle(t *testing.T) {
+	_, err := Read(writeConfig(t, `
+projectid = "testproject"
+defaultzone = "us-east1-b"
+defaultuserdata = "#cloud-config"
+
+[instance.a]
+	extends = "b"
+	family = "cos-cloud"
+	hardware = "e2-small"
+
+[instance.b]
+	extends = "a"
+
+[instance.c]
+	extends = "nothing"
+`))
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Read got error %v, want Problems", err)
+	}
+
+	got := make([]string, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, p.Error()[strings.LastIndex(p.Error(), ".toml:")+len(".toml:"):])
+	}
+	want := []string{
+		"7: instance a: extends cycle a -> b -> a",
+		"12: instance b: extends cycle b -> a -> b",
+		"15: instance c: extends undefined instance type \"nothing\"",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteInstance(t *testing.T) {
+	settings, err := Read(writeConfig(t, extendsconfig))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	var sb strings.Builder
+	if err := settings.WriteInstance(&sb, "medium"); err != nil {
+		t.Fatalf("WriteInstance: %v", err)
+	}
+	want := `[instance]
+  [instance.medium]
+    family = "cos-cloud"
+    hardware = "e2-medium"
+    disksize = 50
+    zone = "us-west1-a"
+    githost = "https://git.example.com/setup.git"
+    userdata = "#cloud-config base"
+`
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("WriteInstance mismatch (-want +got):\n%s", diff)
+	}
+}
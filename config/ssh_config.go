@@ -2,13 +2,18 @@ package config
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/user"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"text/template"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 type fieldValues struct {
@@ -16,24 +21,56 @@ type fieldValues struct {
 	IP     string
 	Header string
 	Footer string
+
+	// Pinned is true when the node's host key is in knownHostsFile.
+	Pinned bool
+
+	// JumpHost, when set, is the ssh jump host for reaching IP.
+	JumpHost string
+
+	// Project, when set, is the GCP project of the node. See
+	// projectLine.
+	Project string
 }
 
+// knownHostsFile is the known_hosts file, relative to the home
+// directory, that holds the pinned host keys of gocloud nodes under
+// their alias names.
+const knownHostsFile = ".ssh/gocloud_known_hosts"
+
 // TODO(rjk): Consider letting the block innards be specified by the config file.
 const machineblock = `
 {{.Header}}
+{{- if .Project}}
+# gocloud project {{.Project}}
+{{- end}}
 Host {{.Name}}
 	HostName {{.IP}}
 	ControlPath ~/.ssh/controlmasters/{{.Name}}-%r@%h:%p
 	ControlMaster auto
 	ControlPersist yes
 	CheckHostIP=no
+{{- if .JumpHost}}
+	ProxyJump {{.JumpHost}}
+{{- end}}
+{{- if .Pinned}}
+	HostKeyAlias {{.Name}}
+	UserKnownHostsFile ~/.ssh/gocloud_known_hosts
+	StrictHostKeyChecking yes
+{{- else}}
 	StrictHostKeyChecking no
+{{- end}}
 {{.Footer}}
 `
 
 const header = "#-- gocloud %s --"
 const footer = "#---"
 
+// projectLineRegexp matches the line of a machine configuration block
+// that records the project of the node. Blocks written before gocloud
+// recorded the project don't have it.
+var projectLineRegexp = regexp.MustCompile(`(?m)^# gocloud project (\S+)$`)
+
 func makeFieldValues(name, ip string) *fieldValues {
 	return &fieldValues{
 		Name:   name,
@@ -45,28 +82,42 @@ func makeFieldValues(name, ip string) *fieldValues {
 
 // AddSshAlias adds a block to the user's ssh configuration file that
 // provides an ssh alias to (typically of a created GCP node) ip
-// (address).
-func AddSshAlias(name, ip string) error {
-	u, err := user.Current()
+// (address), reached through jumphost if it isn't empty. The block
+// records project, the node's project, if it isn't empty. When hostkey
+// is not nil, it pins the node's host key: ssh then refuses to connect
+// to a node with any other key.
+func AddSshAlias(project, name, ip, jumphost string, hostkey ssh.PublicKey) error {
+	h, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Errorf("no user, can't update ~/.ssh/config: %v", err)
+		return fmt.Errorf("no home, can't update ~/.ssh/config: %v", err)
 	}
-	h := u.HomeDir
 
-	p := filepath.Join(u.HomeDir, ".ssh", "controlmasters")
+	p := filepath.Join(h, ".ssh", "controlmasters")
 	if err := os.MkdirAll(p, 0700); err != nil {
 		return fmt.Errorf("can't make %q: %v", p, err)
 	}
-	p = filepath.Join(h, ".ssh", "config")
 
-	return insertNameBlock(p, makeFieldValues(name, ip))
+	fields := makeFieldValues(name, ip)
+	fields.JumpHost = jumphost
+	fields.Project = project
+	if hostkey != nil {
+		if err := pinHostKey(filepath.Join(h, knownHostsFile), name, hostkey); err != nil {
+			return err
+		}
+		fields.Pinned = true
+	}
+
+	p = filepath.Join(h, ".ssh", "config")
+	return insertNameBlock(p, fields)
 }
 
 // insertNameBlock updates sshfile (which needs to be an ssh config file)
 // with a machine configuration block specified by fields.
 func insertNameBlock(sshfile string, fields *fieldValues) error {
-	// TODO(rjk): need to error check templates before letting them be configurable.
-	var t = template.Must(template.New("sshblock").Parse(machineblock))
+	block, err := renderNameBlock(fields)
+	if err != nil {
+		return err
+	}
 
 	filebuffer, err := ioutil.ReadFile(sshfile)
 	if err != nil {
@@ -83,25 +134,22 @@ func insertNameBlock(sshfile string, fields *fieldValues) error {
 	defer os.Remove(tmpfilename)
 	fd := bufio.NewWriter(tfd)
 
-	pattern := "(?s)" + "\n?" + fields.Header + ".*?" + fields.Footer + "\n?"
-	// log.Printf("complete regexp %q", pattern )
-	re := regexp.MustCompile(pattern)
-	locs := re.FindIndex(filebuffer)
+	locs := nameBlockRegexp(fields).FindIndex(filebuffer)
 	// log.Println("locs", locs)
 
 	if locs == nil {
 		if _, err := fd.Write(filebuffer); err != nil {
 			return fmt.Errorf("can't write tmp %q: %v", tmpfilename, err)
 		}
-		if err := t.Execute(fd, fields); err != nil {
-			return fmt.Errorf("can't expand machineblock: %v", err)
+		if _, err := fd.WriteString(block); err != nil {
+			return fmt.Errorf("can't write tmp %q: %v", tmpfilename, err)
 		}
 	} else {
 		if _, err := fd.Write(filebuffer[0:locs[0]]); err != nil {
 			return fmt.Errorf("can't write tmp %q: %v", tmpfilename, err)
 		}
-		if err := t.Execute(fd, fields); err != nil {
-			return fmt.Errorf("can't expand machineblock: %v", err)
+		if _, err := fd.WriteString(block); err != nil {
+			return fmt.Errorf("can't write tmp %q: %v", tmpfilename, err)
 		}
 		if _, err := fd.Write(filebuffer[locs[1]:]); err != nil {
 			return fmt.Errorf("can't write tmp %q: %v", tmpfilename, err)
@@ -115,6 +163,131 @@ func insertNameBlock(sshfile string, fields *fieldValues) error {
 	return SafeReplaceFile(tmpfilename, sshfile)
 }
 
+// renderNameBlock returns the machine configuration block for fields.
+func renderNameBlock(fields *fieldValues) (string, error) {
+	// TODO(rjk): need to error check templates before letting them be configurable.
+	var t = template.Must(template.New("sshblock").Parse(machineblock))
+
+	var sb strings.Builder
+	if err := t.Execute(&sb, fields); err != nil {
+		return "", fmt.Errorf("can't expand machineblock: %v", err)
+	}
+	return sb.String(), nil
+}
+
+// nameBlockRegexp returns a regexp matching the machine configuration
+// block for fields and the newlines around it.
+func nameBlockRegexp(fields *fieldValues) *regexp.Regexp {
+	pattern := "(?s)" + "\n?" + regexp.QuoteMeta(fields.Header) + ".*?" + regexp.QuoteMeta(fields.Footer) + "\n?"
+	// log.Printf("complete regexp %q", pattern )
+	return regexp.MustCompile(pattern)
+}
+
+// RemoveSshAlias undoes AddSshAlias: it stops the ControlMaster for
+// name, removes the block for name from the user's ssh configuration
+// file, the ControlMaster sockets for name, the pinned host key and the
+// known_hosts entries for name and its address.
+func RemoveSshAlias(name string) error {
+	h, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("no home, can't update ~/.ssh/config: %v", err)
+	}
+	sshdir := filepath.Join(h, ".ssh")
+	sshconfig := filepath.Join(sshdir, "config")
+
+	// The block says where the ControlMaster's socket is.
+	if block := nameBlockRegexp(makeFieldValues(name, "")).Find(readFileOrEmpty(sshconfig)); block != nil {
+		exitControlMaster(sshconfig, name)
+	}
+
+	ip, err := removeNameBlock(sshconfig, makeFieldValues(name, ""))
+	if err != nil {
+		return err
+	}
+
+	// The ControlPath in machineblock expands %h to the HostName.
+	if ip != "" {
+		sockets, err := filepath.Glob(filepath.Join(sshdir, "controlmasters", name+"-*@"+ip+":*"))
+		if err != nil {
+			return fmt.Errorf("can't find controlmaster sockets for %s: %v", name, err)
+		}
+		for _, s := range sockets {
+			if err := os.Remove(s); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("can't remove controlmaster socket: %v", err)
+			}
+		}
+	}
+
+	if err := removeKnownHosts(filepath.Join(h, knownHostsFile), []string{name}); err != nil {
+		return err
+	}
+
+	hosts := []string{name}
+	if ip != "" {
+		hosts = append(hosts, ip)
+	}
+	return removeKnownHosts(filepath.Join(sshdir, "known_hosts"), hosts)
+}
+
+// removeNameBlock removes the machine configuration block specified by
+// fields from sshfile and returns the HostName that the block had. It
+// is not an error for sshfile or the block to not exist.
+func removeNameBlock(sshfile string, fields *fieldValues) (string, error) {
+	filebuffer, err := ioutil.ReadFile(sshfile)
+	if err != nil {
+		// No sshfile is not an error.
+		return "", nil
+	}
+
+	locs := nameBlockRegexp(fields).FindIndex(filebuffer)
+	if locs == nil {
+		return "", nil
+	}
+
+	ip := ""
+	if m := hostNameRegexp.FindSubmatch(filebuffer[locs[0]:locs[1]]); m != nil {
+		ip = string(m[1])
+	}
+
+	tmpfilename := sshfile + ".tmp"
+	tfd, err := os.OpenFile(tmpfilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", fmt.Errorf("can't create tmp %q: %v", tmpfilename, err)
+	}
+	defer tfd.Close()
+	defer os.Remove(tmpfilename)
+
+	if _, err := tfd.Write(filebuffer[0:locs[0]]); err != nil {
+		return "", fmt.Errorf("can't write tmp %q: %v", tmpfilename, err)
+	}
+	if _, err := tfd.Write(filebuffer[locs[1]:]); err != nil {
+		return "", fmt.Errorf("can't write tmp %q: %v", tmpfilename, err)
+	}
+	return ip, SafeReplaceFile(tmpfilename, sshfile)
+}
+
+var hostNameRegexp = regexp.MustCompile(`(?m)^\s*HostName\s+(\S+)\s*$`)
+
+// readFileOrEmpty returns the contents of file or nothing if it can't be
+// read.
+func readFileOrEmpty(file string) []byte {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// exitControlMaster asks the ControlMaster of the ssh alias name in the
+// ssh configuration file sshconfig to exit. It is a variable so that
+// tests can replace it. There's nothing to do when no master is
+// running so it ignores ssh's failure.
+var exitControlMaster = func(sshconfig, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exec.CommandContext(ctx, "ssh", "-F", sshconfig, "-O", "exit", name).Run()
+}
+
 // Copied from wikitools
 func SafeReplaceFile(newpath, oldpath string) error {
 	backup := oldpath + ".back"
@@ -1,11 +1,16 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 const createcase = `
@@ -261,3 +266,268 @@ func TestInsertNameBlock(t *testing.T) {
 		t.Errorf("replaceend:  mismatch (-want +got):\n%s", diff)
 	}
 }
+
+const removemiddle = `
+#-- gocloud instancename --
+Host instancename
+	HostName 10.0.2.1
+	ControlPath ~/.ssh/controlmasters/instancename-%r@%h:%p
+	ControlMaster auto
+	ControlPersist yes
+	CheckHostIP=no
+	StrictHostKeyChecking no
+#---
+
+#-- gocloud suffixinstance --
+Host suffixinstance
+	HostName 10.0.3.3
+	ControlPath ~/.ssh/controlmasters/suffixinstance-%r@%h:%p
+	ControlMaster auto
+	ControlPersist yes
+	CheckHostIP=no
+	StrictHostKeyChecking no
+#---
+`
+
+const removeend = `
+#-- gocloud instancename --
+Host instancename
+	HostName 10.0.2.1
+	ControlPath ~/.ssh/controlmasters/instancename-%r@%h:%p
+	ControlMaster auto
+	ControlPersist yes
+	CheckHostIP=no
+	StrictHostKeyChecking no
+#---
+`
+
+func TestRemoveNameBlock(t *testing.T) {
+	dir := t.TempDir()
+	newfile := filepath.Join(dir, "config")
+
+	// No file is not an error.
+	if _, err := removeNameBlock(newfile, makeFieldValues("instancename", "")); err != nil {
+		t.Fatal("remove from missing file failed", err)
+	}
+
+	if err := ioutil.WriteFile(newfile, []byte(replaceend), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{"secondinstance", "10.0.1.3", removemiddle},
+		{"secondinstance", "", removemiddle},
+		{"suffixinstance", "10.0.3.3", removeend},
+		{"instancename", "10.0.2.1", ""},
+	} {
+		ip, err := removeNameBlock(newfile, makeFieldValues(tc.name, ""))
+		if err != nil {
+			t.Fatal("can't remove block", err)
+		}
+		if ip != tc.ip {
+			t.Errorf("removing %s got HostName %q, want %q", tc.name, ip, tc.ip)
+		}
+
+		contents, err := ioutil.ReadFile(newfile)
+		if err != nil {
+			t.Fatal("lost the file", err)
+		}
+		if diff := cmp.Diff(tc.want, string(contents)); diff != "" {
+			t.Errorf("removing %s: mismatch (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestRemoveSshAlias(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if err := AddSshAlias("", "doomed", "10.0.0.9", "", nil); err != nil {
+		t.Fatal("can't add alias", err)
+	}
+	if err := AddSshAlias("", "doomed-too", "10.0.0.10", "", nil); err != nil {
+		t.Fatal("can't add alias", err)
+	}
+
+	masters := filepath.Join(home, ".ssh", "controlmasters")
+	for _, f := range []string{"doomed-rjk@10.0.0.9:22", "doomed-too-rjk@10.0.0.10:22"} {
+		if err := ioutil.WriteFile(filepath.Join(masters, f), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The |1| line is a hashed entry for 10.0.0.9.
+	const knownhosts = `10.0.0.9 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA
+10.0.0.10 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB
+doomed,10.0.0.1 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIC
+[10.0.0.9]:2222 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAID
+|1|c2FsdHNhbHRzYWx0c2FsdHNhbHQ=|s20Qk+OYyxNAZXhc8fhZ2j2fWFI= ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE
+# doomed
+`
+	knownhostsfile := filepath.Join(home, ".ssh", "known_hosts")
+	if err := ioutil.WriteFile(knownhostsfile, []byte(knownhosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var exited []string
+	defer func(f func(string, string)) { exitControlMaster = f }(exitControlMaster)
+	exitControlMaster = func(sshconfig, name string) {
+		exited = append(exited, name)
+	}
+
+	if err := RemoveSshAlias("doomed"); err != nil {
+		t.Fatal("RemoveSshAlias failed", err)
+	}
+	if diff := cmp.Diff([]string{"doomed"}, exited); diff != "" {
+		t.Errorf("ControlMaster exits: mismatch (-want +got):\n%s", diff)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(makeBlock(t, "doomed-too", "10.0.0.10"), string(contents)); diff != "" {
+		t.Errorf("ssh config: mismatch (-want +got):\n%s", diff)
+	}
+
+	left, err := filepath.Glob(filepath.Join(masters, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{filepath.Join(masters, "doomed-too-rjk@10.0.0.10:22")}, left); diff != "" {
+		t.Errorf("controlmasters: mismatch (-want +got):\n%s", diff)
+	}
+
+	contents, err = ioutil.ReadFile(knownhostsfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "10.0.0.10 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB\n10.0.0.1 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIC\n# doomed\n"
+	if diff := cmp.Diff(want, string(contents)); diff != "" {
+		t.Errorf("known_hosts: mismatch (-want +got):\n%s", diff)
+	}
+
+	// Removing it again does nothing.
+	if err := RemoveSshAlias("doomed"); err != nil {
+		t.Fatal("RemoveSshAlias of a missing alias failed", err)
+	}
+}
+
+// makeBlock returns the ssh configuration block for name and ip.
+func makeBlock(t *testing.T, name, ip string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config")
+	if err := insertNameBlock(file, makeFieldValues(name, ip)); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+const pinnedcase = `
+#-- gocloud pinned --
+Host pinned
+	HostName 10.0.0.4
+	ControlPath ~/.ssh/controlmasters/pinned-%r@%h:%p
+	ControlMaster auto
+	ControlPersist yes
+	CheckHostIP=no
+	HostKeyAlias pinned
+	UserKnownHostsFile ~/.ssh/gocloud_known_hosts
+	StrictHostKeyChecking yes
+#---
+`
+
+func TestAddSshAliasPinsHostKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	keys := make([]ssh.PublicKey, 0, 2)
+	for i := 0; i < 2; i++ {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := AddSshAlias("", "other", "10.0.0.5", "", keys[1]); err != nil {
+		t.Fatal("can't add alias", err)
+	}
+	for _, key := range keys {
+		if err := AddSshAlias("", "pinned", "10.0.0.4", "", key); err != nil {
+			t.Fatal("can't add alias", err)
+		}
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(contents), pinnedcase) {
+		t.Errorf("ssh config doesn't end with the pinned block:\n%s", contents)
+	}
+
+	// Re-adding the alias replaces the pinned key.
+	knownhostsfile := filepath.Join(home, ".ssh", "gocloud_known_hosts")
+	contents, err = ioutil.ReadFile(knownhostsfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := knownhosts.Line([]string{"other"}, keys[1]) + "\n" + knownhosts.Line([]string{"pinned"}, keys[1]) + "\n"
+	if diff := cmp.Diff(want, string(contents)); diff != "" {
+		t.Errorf("gocloud_known_hosts: mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := RemoveSshAlias("pinned"); err != nil {
+		t.Fatal("RemoveSshAlias failed", err)
+	}
+	contents, err = ioutil.ReadFile(knownhostsfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = knownhosts.Line([]string{"other"}, keys[1]) + "\n"
+	if diff := cmp.Diff(want, string(contents)); diff != "" {
+		t.Errorf("gocloud_known_hosts after remove: mismatch (-want +got):\n%s", diff)
+	}
+}
+
+const jumpcase = `
+#-- gocloud hidden --
+Host hidden
+	HostName 10.128.0.9
+	ControlPath ~/.ssh/controlmasters/hidden-%r@%h:%p
+	ControlMaster auto
+	ControlPersist yes
+	CheckHostIP=no
+	ProxyJump me@bastion.example.com:2222
+	StrictHostKeyChecking no
+#---
+`
+
+func TestAddSshAliasJumpHost(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if err := AddSshAlias("", "hidden", "10.128.0.9", "me@bastion.example.com:2222", nil); err != nil {
+		t.Fatal("can't add alias", err)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(jumpcase, string(contents)); diff != "" {
+		t.Errorf("ssh config mismatch (-want +got):\n%s", diff)
+	}
+}
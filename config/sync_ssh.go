@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SshAlias describes the ssh alias of a running node as AddSshAlias
+// writes it.
+type SshAlias struct {
+	Project  string
+	Name     string
+	Addr     string
+	JumpHost string
+
+	// HostKey, when not nil, is pinned for the node.
+	HostKey ssh.PublicKey
+}
+
+// SshAliasChange is a change to the block for one node in the user's
+// ssh configuration file.
+type SshAliasChange struct {
+	Alias SshAlias
+
+	// Old and New are the block before and after the change. Old is
+	// empty when the block is added and New when it is removed.
+	Old, New string
+}
+
+// Apply makes the change to the user's ssh configuration.
+func (ch *SshAliasChange) Apply() error {
+	if ch.New == "" {
+		return RemoveSshAlias(ch.Alias.Name)
+	}
+	a := ch.Alias
+	return AddSshAlias(a.Project, a.Name, a.Addr, a.JumpHost, a.HostKey)
+}
+
+// Diff writes the change to w as a line diff of the block.
+func (ch *SshAliasChange) Diff(w io.Writer) {
+	switch {
+	case ch.Old == "":
+		fmt.Fprintf(w, "add %s:\n", ch.Alias.Name)
+	case ch.New == "":
+		fmt.Fprintf(w, "remove %s:\n", ch.Alias.Name)
+	default:
+		fmt.Fprintf(w, "update %s:\n", ch.Alias.Name)
+	}
+	for _, l := range diffLines(splitBlock(ch.Old), splitBlock(ch.New)) {
+		fmt.Fprintln(w, l)
+	}
+}
+
+// blockHeaderRegexp matches the header of every machine configuration
+// block. See header.
+var blockHeaderRegexp = regexp.MustCompile(`(?m)^#-- gocloud (\S+) --$`)
+
+// PlanSshAliases compares the user's ssh configuration file with the
+// aliases of the running nodes of project and returns the changes that
+// bring it up to date, sorted by name. Blocks of project for nodes that
+// aren't in aliases are removed unless their names are in keep, e.g.
+// because the nodes exist but aren't running. So are blocks that don't
+// record their project, written before gocloud recorded it. Blocks of
+// other projects are left alone.
+func PlanSshAliases(project string, aliases []SshAlias, keep []string) ([]*SshAliasChange, error) {
+	h, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("no home, can't read ~/.ssh/config: %v", err)
+	}
+	filebuffer, err := ioutil.ReadFile(filepath.Join(h, ".ssh", "config"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't read ~/.ssh/config: %v", err)
+	}
+
+	var changes []*SshAliasChange
+	wanted := make(map[string]bool, len(aliases)+len(keep))
+	for _, a := range aliases {
+		wanted[a.Name] = true
+		fields := makeFieldValues(a.Name, a.Addr)
+		fields.JumpHost = a.JumpHost
+		fields.Pinned = a.HostKey != nil
+		fields.Project = a.Project
+		block, err := renderNameBlock(fields)
+		if err != nil {
+			return nil, err
+		}
+		block = strings.Trim(block, "\n")
+		old := strings.Trim(string(nameBlockRegexp(fields).Find(filebuffer)), "\n")
+		if old != block {
+			changes = append(changes, &SshAliasChange{Alias: a, Old: old, New: block})
+		}
+	}
+	for _, name := range keep {
+		wanted[name] = true
+	}
+
+	for _, m := range blockHeaderRegexp.FindAllSubmatch(filebuffer, -1) {
+		name := string(m[1])
+		if wanted[name] {
+			continue
+		}
+		wanted[name] = true
+		old := strings.Trim(string(nameBlockRegexp(makeFieldValues(name, "")).Find(filebuffer)), "\n")
+		if pm := projectLineRegexp.FindStringSubmatch(old); pm != nil && pm[1] != project {
+			continue
+		}
+		changes = append(changes, &SshAliasChange{Alias: SshAlias{Name: name}, Old: old})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Alias.Name < changes[j].Alias.Name })
+	return changes, nil
+}
+
+// splitBlock splits block into lines. An empty block has none.
+func splitBlock(block string) []string {
+	if block == "" {
+		return nil
+	}
+	return strings.Split(block, "\n")
+}
+
+// diffLines returns the lines of a diff from a to b, prefixing removed
+// lines with -, added lines with + and common lines with a space.
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, "+"+b[j])
+	}
+	return lines
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlanSshAliases(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	for _, a := range []SshAlias{
+		{Project: "proj", Name: "current", Addr: "10.0.0.1"},
+		{Project: "proj", Name: "moved", Addr: "10.0.0.2"},
+		{Project: "proj", Name: "gone", Addr: "10.0.0.3"},
+		{Project: "proj", Name: "stopped", Addr: "10.0.0.4"},
+		{Project: "other", Name: "elsewhere", Addr: "10.0.0.6"},
+		{Name: "legacy", Addr: "10.0.0.7"},
+		{Name: "legacy-stopped", Addr: "10.0.0.8"},
+	} {
+		if err := AddSshAlias(a.Project, a.Name, a.Addr, a.JumpHost, nil); err != nil {
+			t.Fatal("can't add alias", err)
+		}
+	}
+
+	aliases := []SshAlias{
+		{Project: "proj", Name: "current", Addr: "10.0.0.1"},
+		{Project: "proj", Name: "moved", Addr: "10.0.0.9"},
+		{Project: "proj", Name: "new", Addr: "10.0.0.5"},
+	}
+	changes, err := PlanSshAliases("proj", aliases, []string{"stopped", "legacy-stopped"})
+	if err != nil {
+		t.Fatalf("PlanSshAliases: %v", err)
+	}
+
+	var diff strings.Builder
+	names := make([]string, 0, len(changes))
+	for _, ch := range changes {
+		names = append(names, ch.Alias.Name)
+		ch.Diff(&diff)
+	}
+	if d := cmp.Diff([]string{"gone", "legacy", "moved", "new"}, names); d != "" {
+		t.Errorf("changed aliases mismatch (-want +got):\n%s", d)
+	}
+	for _, want := range []string{
+		"remove gone:\n-#-- gocloud gone --\n",
+		"remove legacy:\n-#-- gocloud legacy --\n",
+		"update moved:\n #-- gocloud moved --\n # gocloud project proj\n Host moved\n-\tHostName 10.0.0.2\n+\tHostName 10.0.0.9\n",
+		"add new:\n+#-- gocloud new --\n+# gocloud project proj\n",
+	} {
+		if !strings.Contains(diff.String(), want) {
+			t.Errorf("diff doesn't contain %q:\n%s", want, diff.String())
+		}
+	}
+
+	for _, ch := range changes {
+		if err := ch.Apply(); err != nil {
+			t.Fatalf("Apply %s: %v", ch.Alias.Name, err)
+		}
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := projectBlock(t, "proj", "current", "10.0.0.1") + projectBlock(t, "proj", "moved", "10.0.0.9") +
+		projectBlock(t, "proj", "stopped", "10.0.0.4") + projectBlock(t, "other", "elsewhere", "10.0.0.6") +
+		makeBlock(t, "legacy-stopped", "10.0.0.8") + projectBlock(t, "proj", "new", "10.0.0.5")
+	if d := cmp.Diff(want, string(contents)); d != "" {
+		t.Errorf("ssh config: mismatch (-want +got):\n%s", d)
+	}
+
+	changes, err = PlanSshAliases("proj", aliases, []string{"stopped", "legacy-stopped"})
+	if err != nil {
+		t.Fatalf("PlanSshAliases: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("PlanSshAliases after applying the changes got %d more", len(changes))
+	}
+}
+
+// projectBlock returns the ssh configuration block for name and ip in
+// project.
+func projectBlock(t *testing.T, project, name, ip string) string {
+	t.Helper()
+	fields := makeFieldValues(name, ip)
+	fields.Project = project
+	block, err := renderNameBlock(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines([]string{"a", "b", "c", "d"}, []string{"a", "x", "c", "d", "e"})
+	want := []string{" a", "-b", "+x", " c", " d", "+e"}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("diffLines mismatch (-want +got):\n%s", d)
+	}
+}
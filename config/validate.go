@@ -0,0 +1,449 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Problem is a mistake in a configuration file.
+type Problem struct {
+	File string
+	// Line is the line of File with the mistake or 0 if it's unknown.
+	Line    int
+	Message string
+}
+
+func (p *Problem) Error() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// Problems is every mistake found in a configuration file, in order of
+// position.
+type Problems []*Problem
+
+func (ps Problems) Error() string {
+	msgs := make([]string, 0, len(ps))
+	for _, p := range ps {
+		msgs = append(msgs, p.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+var (
+	zoneRegexp        = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+-[a-z]$`)
+	machineTypeRegexp = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)+$`)
+	nameRegexp        = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	jumpHostRegexp    = regexp.MustCompile(`^([^@\s]+@)?[^@:\s]+(:[0-9]+)?$`)
+	emailRegexp       = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[a-z]+$`)
+	scopeRegexp       = regexp.MustCompile(`^(https://\S+|[a-z][-a-z0-9._]*)$`)
+	imagePathRegexp   = regexp.MustCompile(`^(https://www\.googleapis\.com/compute/v1/)?projects/[^/\s]+/global/images/[^/\s]+$`)
+	channelRegexp     = regexp.MustCompile(`^(stable|beta|dev|lts(-[0-9]+)?)$`)
+	labelKeyRegexp    = regexp.MustCompile(`^[a-z][-_a-z0-9]{0,62}$`)
+	labelValueRegexp  = regexp.MustCompile(`^[-_a-z0-9]{0,63}$`)
+)
+
+// validator accumulates the Problems with a configuration file.
+type validator struct {
+	file     string
+	lines    map[string]int
+	problems Problems
+}
+
+// Problemf returns a Problem with the setting at key, a dotted TOML key
+// path like instance.small.zone, in the configuration file that s was
+// read from. It is for mistakes that can only be found by consulting
+// Compute Engine.
+func (s *Settings) Problemf(key, format string, args ...interface{}) *Problem {
+	v := &validator{file: s.path, lines: s.lines}
+	v.problemf(key, format, args...)
+	return v.problems[0]
+}
+
+// problemf records a problem with the setting at key, a dotted TOML key
+// path.
+func (v *validator) problemf(key, format string, args ...interface{}) {
+	v.problems = append(v.problems, &Problem{
+		File:    v.file,
+		Line:    v.line(key),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// line returns the line that defines key or, failing that, its closest
+// enclosing table.
+func (v *validator) line(key string) int {
+	for key != "" {
+		if l, ok := v.lines[key]; ok {
+			return l
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return 0
+}
+
+// validate checks settings, decoded from the file path with metadata md,
+// returning every Problem found.
+func (s *Settings) validate(path string, md toml.MetaData) error {
+	v := &validator{file: path, lines: s.lines}
+
+	for _, k := range md.Undecoded() {
+		v.problemf(strings.Join(k, "."), "unknown setting %q", k.String())
+	}
+
+	if s.ProjectId == "" {
+		v.problemf("", "projectid must be set")
+	}
+	if s.DefaultZone != "" && !zoneRegexp.MatchString(s.DefaultZone) {
+		v.problemf("defaultzone", "defaultzone %q is not a zone like us-east1-b", s.DefaultZone)
+	}
+	if s.DefaultUserData != "" && s.DefaultUserDataFile != "" {
+		v.problemf("defaultuserdatafile", "set only one of defaultuserdata and defaultuserdatafile")
+	}
+	v.checkFile("defaultuserdatafile", s.DefaultUserDataFile)
+
+	if home, err := os.UserHomeDir(); err == nil {
+		if s.SshPublicKeyFile != "" {
+			v.checkFile("sshpublickey", s.PublicKeyFile(home))
+		}
+		if s.SshPrivateKeyFile != "" {
+			v.checkFile("sshprivatekey", s.PrivateKeyFile(home))
+		}
+	}
+
+	names := make([]string, 0, len(s.InstanceTypes))
+	for name := range s.InstanceTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.validateInstance(v, name)
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Line < v.problems[j].Line
+	})
+	return v.problems
+}
+
+// validateInstance checks the instance configuration name. Instance
+// configurations that others extend can leave required settings to the
+// configurations that extend them.
+func (s *Settings) validateInstance(v *validator, name string) {
+	raw := s.InstanceTypes[name]
+	key := "instance." + name
+
+	if raw.UserData != "" && raw.UserDataFile != "" {
+		v.problemf(key+".userdatafile", "instance %s: set only one of userdata and userdatafile", name)
+	}
+	v.checkFile(key+".userdatafile", raw.UserDataFile)
+	if raw.Hardware != "" && !machineTypeRegexp.MatchString(raw.Hardware) {
+		v.problemf(key+".hardware", "instance %s: hardware %q is not a machine type like e2-small", name, raw.Hardware)
+	}
+	if raw.Zone != "" && !zoneRegexp.MatchString(raw.Zone) {
+		v.problemf(key+".zone", "instance %s: zone %q is not a zone like us-east1-b", name, raw.Zone)
+	}
+	if raw.DiskSize < 0 {
+		v.problemf(key+".disksize", "instance %s: disksize %d is negative", name, raw.DiskSize)
+	}
+	if raw.DiskType != "" && !diskTypes[raw.DiskType] {
+		v.problemf(key+".disktype", "instance %s: disktype %q is not a disk type like pd-balanced", name, raw.DiskType)
+	}
+	if raw.DiskIops < 0 {
+		v.problemf(key+".diskiops", "instance %s: diskiops %d is negative", name, raw.DiskIops)
+	}
+	if raw.DiskThroughput < 0 {
+		v.problemf(key+".diskthroughput", "instance %s: diskthroughput %d is negative", name, raw.DiskThroughput)
+	}
+	if raw.LocalSsds < 0 || raw.LocalSsds > maxLocalSsds {
+		v.problemf(key+".localssds", "instance %s: localssds %d is not between 0 and %d", name, raw.LocalSsds, maxLocalSsds)
+	}
+	if raw.LocalSsdMount != "" && !path.IsAbs(raw.LocalSsdMount) {
+		v.problemf(key+".localssdmount", "instance %s: localssdmount %q is not an absolute path", name, raw.LocalSsdMount)
+	}
+	for _, tag := range raw.Tags {
+		if !nameRegexp.MatchString(tag) {
+			v.problemf(key+".tags", "instance %s: tag %q is not lowercase letters, digits and dashes", name, tag)
+		}
+	}
+	if raw.ServiceAccount != "" && raw.ServiceAccount != "default" && raw.ServiceAccount != "none" && !emailRegexp.MatchString(raw.ServiceAccount) {
+		v.problemf(key+".serviceaccount", "instance %s: serviceaccount %q is not an email address, default or none", name, raw.ServiceAccount)
+	}
+	for _, scope := range raw.Scopes {
+		if !scopeRegexp.MatchString(scope) {
+			v.problemf(key+".scopes", "instance %s: scope %q is not a URL or a name like devstorage.read_only", name, scope)
+		}
+	}
+	if len(raw.Labels) > MaxLabels {
+		v.problemf(key+".labels", "instance %s: %d labels is more than %d", name, len(raw.Labels), MaxLabels)
+	}
+	for _, k := range SortedKeys(raw.Labels) {
+		switch {
+		case !labelKeyRegexp.MatchString(k):
+			v.problemf(key+".labels."+k, "instance %s: label key %q is not lowercase letters, digits, dashes and underscores", name, k)
+		case strings.HasPrefix(k, ReservedLabelPrefix):
+			v.problemf(key+".labels."+k, "instance %s: label key %q is reserved for gocloud", name, k)
+		}
+		if !labelValueRegexp.MatchString(raw.Labels[k]) {
+			v.problemf(key+".labels."+k, "instance %s: label %s value %q is not lowercase letters, digits, dashes and underscores", name, k, raw.Labels[k])
+		}
+	}
+	if raw.ImageFamily != "" && !nameRegexp.MatchString(raw.ImageFamily) {
+		v.problemf(key+".imagefamily", "instance %s: imagefamily %q is not lowercase letters, digits and dashes", name, raw.ImageFamily)
+	}
+	if raw.Image != "" && !nameRegexp.MatchString(raw.Image) && !imagePathRegexp.MatchString(raw.Image) {
+		v.problemf(key+".image", "instance %s: image %q is not an image name or projects/<project>/global/images/<name>", name, raw.Image)
+	}
+	if raw.Channel != "" && !channelRegexp.MatchString(raw.Channel) {
+		v.problemf(key+".channel", "instance %s: channel %q is not stable, beta, dev, lts or lts-<milestone>", name, raw.Channel)
+	}
+	switch raw.ExternalIp {
+	case "", "ephemeral", "static", "none":
+	default:
+		v.problemf(key+".externalip", "instance %s: externalip %q is not ephemeral, static or none", name, raw.ExternalIp)
+	}
+	if raw.JumpHost != "" && !jumpHostRegexp.MatchString(raw.JumpHost) {
+		v.problemf(key+".jumphost", "instance %s: jumphost %q is not [user@]host[:port]", name, raw.JumpHost)
+	}
+	switch raw.Provisioning {
+	case "", "standard", "spot", "preemptible":
+	default:
+		v.problemf(key+".provisioning", "instance %s: provisioning %q is not standard, spot or preemptible", name, raw.Provisioning)
+	}
+	switch raw.TerminationAction {
+	case "", "stop", "delete":
+	default:
+		v.problemf(key+".terminationaction", "instance %s: terminationaction %q is not stop or delete", name, raw.TerminationAction)
+	}
+	if raw.MaxRunDuration != "" {
+		if d, err := time.ParseDuration(raw.MaxRunDuration); err != nil || d < 30*time.Second || d > 120*24*time.Hour {
+			v.problemf(key+".maxrunduration", "instance %s: maxrunduration %q is not a duration between 30s and 2880h", name, raw.MaxRunDuration)
+		}
+	}
+
+	for i, d := range raw.Disks {
+		validateDisk(v, name, i, d)
+	}
+
+	ic, err := s.Instance(name)
+	if err != nil {
+		v.problemf(key+".extends", "%v", err)
+		return
+	}
+
+	switch {
+	case (ic.Provisioning == "spot" || ic.Provisioning == "preemptible") && ic.AutomaticRestart != nil && *ic.AutomaticRestart:
+		v.problemf(key+".automaticrestart", "instance %s: %s nodes can't restart automatically", name, ic.Provisioning)
+	case ic.Provisioning == "preemptible" && ic.MaxRunDuration != "":
+		v.problemf(key+".maxrunduration", "instance %s: preemptible nodes can't have a maxrunduration: use spot", name)
+	case ic.MaxRunDuration != "" && ic.TerminationAction == "":
+		v.problemf(key+".maxrunduration", "instance %s: maxrunduration needs a terminationaction", name)
+	case ic.TerminationAction != "" && ic.Provisioning != "spot" && ic.MaxRunDuration == "":
+		v.problemf(key+".terminationaction", "instance %s: terminationaction needs spot provisioning or a maxrunduration", name)
+	}
+	if ic.DiskIops > 0 && ic.DiskType != "pd-extreme" && !strings.HasPrefix(ic.DiskType, "hyperdisk-") {
+		v.problemf(key+".diskiops", "instance %s: diskiops needs a pd-extreme or hyperdisk disktype", name)
+	}
+	if ic.DiskThroughput > 0 && ic.DiskType != "hyperdisk-balanced" && ic.DiskType != "hyperdisk-throughput" {
+		v.problemf(key+".diskthroughput", "instance %s: diskthroughput needs a hyperdisk-balanced or hyperdisk-throughput disktype", name)
+	}
+	if ic.ServiceAccount == "none" && len(ic.Scopes) > 0 {
+		v.problemf(key+".scopes", "instance %s: scopes need a serviceaccount", name)
+	}
+	if ic.Image != "" && ic.ImageFamily != "" {
+		v.problemf(key+".image", "instance %s: set only one of image and imagefamily", name)
+	}
+	if ic.Channel != "" && (ic.ImageFamily != "" || ic.Image != "" || s.ImageProject(name) != "cos-cloud") {
+		v.problemf(key+".channel", "instance %s: channel only applies to cos-cloud images chosen without imagefamily or image", name)
+	}
+	if ic.JumpHost != "" && ic.ExternalIp != "none" {
+		v.problemf(key+".jumphost", "instance %s: jumphost needs externalip = \"none\"", name)
+	}
+	if s.isExtended(name) {
+		return
+	}
+
+	if ic.Family == "" && ic.ImageProject == "" && ic.ImageFamily == "" && ic.Image == "" {
+		v.problemf(key, "instance %s: family, imagefamily or image must be set", name)
+	} else if project := s.ImageProject(name); ic.ImageFamily == "" && ic.Image == "" && project != "cos-cloud" {
+		v.problemf(key, "instance %s: set imagefamily or image to choose an image from %s", name, project)
+	}
+	if ic.Hardware == "" {
+		v.problemf(key, "instance %s: hardware must be set", name)
+	}
+	if ic.Zone == "" && s.DefaultZone == "" {
+		v.problemf(key, "instance %s: zone must be set when there is no defaultzone", name)
+	}
+	if ic.UserData == "" && ic.UserDataFile == "" && s.DefaultUserData == "" && s.DefaultUserDataFile == "" {
+		v.problemf(key, "instance %s: no userdata: set userdata, userdatafile, defaultuserdata or defaultuserdatafile", name)
+	}
+	// A missing user data file is reported above.
+	if userdata, err := s.UserData(name); ic.HostKey && err == nil && !strings.Contains(userdata, HostKeyMetadataKey) {
+		v.problemf(key+".hostkey", "instance %s: hostkey needs userdata that installs the %s metadata attribute as the ssh host key", name, HostKeyMetadataKey)
+	}
+}
+
+// HostKeyMetadataKey is the metadata attribute holding the private ssh
+// host key that gocloud delivers to nodes with InstanceConfig.HostKey.
+const HostKeyMetadataKey = "sshhostkey"
+
+// maxLocalSsds is the most local SSDs that a node can have.
+const maxLocalSsds = 24
+
+// MaxLabels is the most labels that an instance configuration can add
+// to a node. Compute Engine allows 64 and gocloud adds its own.
+const MaxLabels = 56
+
+// ReservedLabelPrefix starts the keys of the labels that gocloud gives
+// every node.
+const ReservedLabelPrefix = "gocloud-"
+
+// SortedKeys returns the keys of m in order.
+func SortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diskTypes are the types that a boot or data disk can have.
+var diskTypes = map[string]bool{
+	"pd-standard":          true,
+	"pd-balanced":          true,
+	"pd-ssd":               true,
+	"pd-extreme":           true,
+	"hyperdisk-balanced":   true,
+	"hyperdisk-extreme":    true,
+	"hyperdisk-throughput": true,
+}
+
+// validateDisk checks d, the i-th data disk of the instance
+// configuration name.
+func validateDisk(v *validator, name string, i int, d DiskConfig) {
+	key := "instance." + name + ".disk"
+	prefix := fmt.Sprintf("instance %s: disk %d", name, i+1)
+
+	if d.Name == "" {
+		v.problemf(key, "%s: name must be set", prefix)
+	} else if n, err := d.DiskName("node", name); err != nil {
+		v.problemf(key, "%s: %v", prefix, err)
+	} else if !nameRegexp.MatchString(n) {
+		v.problemf(key, "%s: name %q makes %q, which is not a disk name", prefix, d.Name, n)
+	}
+	if d.Size < 0 {
+		v.problemf(key, "%s: size %d is negative", prefix, d.Size)
+	}
+	if d.Type != "" && !diskTypes[d.Type] {
+		v.problemf(key, "%s: type %q is not a disk type like pd-balanced", prefix, d.Type)
+	}
+	switch d.Mode {
+	case "", "rw":
+	case "ro":
+		if !d.Reuse {
+			v.problemf(key, "%s: a read-only disk must reuse an existing disk", prefix)
+		}
+		if d.AutoDelete {
+			v.problemf(key, "%s: a read-only disk can't be deleted with the node", prefix)
+		}
+	default:
+		v.problemf(key, "%s: mode %q is not rw or ro", prefix, d.Mode)
+	}
+}
+
+// isExtended returns true if another instance configuration extends
+// name.
+func (s *Settings) isExtended(name string) bool {
+	for _, ic := range s.InstanceTypes {
+		if ic.Extends == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFile records a problem if the file path set by key doesn't exist.
+func (v *validator) checkFile(key, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.problemf(key, "%s: %v", key, err)
+	}
+}
+
+var (
+	tableRegexp = regexp.MustCompile(`^\[\[?\s*([^\]]+?)\s*\]\]?`)
+	keyRegexp   = regexp.MustCompile(`^([A-Za-z0-9_."' -]+?)\s*=`)
+)
+
+// keyLines returns the line defining each table and key in the TOML
+// document contents, indexed by dotted key path. It understands enough
+// TOML to find where settings are, not to parse them.
+func keyLines(contents []byte) map[string]int {
+	lines := make(map[string]int)
+	table := ""
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	multiline := false
+	for n := 1; scanner.Scan(); n++ {
+		l := strings.TrimSpace(scanner.Text())
+
+		// Skip the contents of multi-line strings.
+		if c := strings.Count(l, `"""`) + strings.Count(l, `'''`); c%2 == 1 {
+			wasmultiline := multiline
+			multiline = !multiline
+			if wasmultiline {
+				continue
+			}
+		} else if multiline {
+			continue
+		}
+
+		if m := tableRegexp.FindStringSubmatch(l); m != nil {
+			table = dottedKey(m[1])
+			if _, ok := lines[table]; !ok {
+				lines[table] = n
+			}
+			continue
+		}
+		if m := keyRegexp.FindStringSubmatch(l); m != nil {
+			key := dottedKey(m[1])
+			if table != "" {
+				key = table + "." + key
+			}
+			if _, ok := lines[key]; !ok {
+				lines[key] = n
+			}
+		}
+	}
+	return lines
+}
+
+// dottedKey normalizes a TOML key by removing quotes and the spaces
+// around dots.
+func dottedKey(k string) string {
+	parts := strings.Split(k, ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(p), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,513 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const goodconfig = `
+defaultzone = "us-east1-b"
+projectid = "testproject"
+defaultuserdatafile = "cloud-config.yaml"
+
+[instance.small]
+	hardware = "e2-small"
+	family = "cos-cloud"
+
+[instance.inline]
+	hardware = "n2-standard-4"
+	family = "cos-cloud"
+	zone = "us-west1-a"
+	userdata = """
+#cloud-config
+hardwear = "not a setting"
+"""
+`
+
+const badconfig = `
+defaultzone = "us-east1"
+projectid = "testproject"
+sshpublickey = "missing.pub"
+
+[instance.small]
+	hardwear = "e2-small"
+	family = "cos-cloud"
+
+[instance.big]
+	hardware = "E2 Standard"
+	zone = "us-west1-a"
+	userdatafile = "missing.yaml"
+	disksize = -1
+`
+
+// writeConfig writes contents to a configuration file in a new
+// directory along with cloud-config.yaml and returns its path.
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cloud-config.yaml"), []byte("#cloud-config\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "gocloud.toml")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadValid(t *testing.T) {
+	path := writeConfig(t, goodconfig)
+
+	settings, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	userdata, err := settings.UserData("small")
+	if err != nil {
+		t.Fatalf("UserData: %v", err)
+	}
+	if got, want := userdata, "#cloud-config\n"; got != want {
+		t.Errorf("UserData(small) got %q, want %q", got, want)
+	}
+
+	userdata, err = settings.UserData("inline")
+	if err != nil {
+		t.Fatalf("UserData: %v", err)
+	}
+	if got, want := userdata, "#cloud-config\nhardwear = \"not a setting\"\n"; got != want {
+		t.Errorf("UserData(inline) got %q, want %q", got, want)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := writeConfig(t, badconfig)
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Read got error %v, want Problems", err)
+	}
+
+	type problem struct {
+		Line    int
+		Message string
+	}
+	got := make([]problem, 0, len(problems))
+	for _, p := range problems {
+		if p.File != path {
+			t.Errorf("problem %v has file %q, want %q", p, p.File, path)
+		}
+		got = append(got, problem{p.Line, p.Message})
+	}
+
+	dir := filepath.Dir(path)
+	home := os.Getenv("HOME")
+	want := []problem{
+		{2, `defaultzone "us-east1" is not a zone like us-east1-b`},
+		{4, "sshpublickey: stat " + filepath.Join(home, ".ssh", "missing.pub") + ": no such file or directory"},
+		{6, "instance small: hardware must be set"},
+		{6, "instance small: no userdata: set userdata, userdatafile, defaultuserdata or defaultuserdatafile"},
+		{7, `unknown setting "instance.small.hardwear"`},
+		{10, "instance big: family, imagefamily or image must be set"},
+		{11, `instance big: hardware "E2 Standard" is not a machine type like e2-small`},
+		{13, "instance.big.userdatafile: stat " + filepath.Join(dir, "missing.yaml") + ": no such file or directory"},
+		{14, "instance big: disksize -1 is negative"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadSyntaxError(t *testing.T) {
+	path := writeConfig(t, "projectid = \"testproject\"\ndefaultzone = us-east1-b\n[instance.small]\n")
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) || len(problems) != 1 {
+		t.Fatalf("Read got error %v, want one Problem", err)
+	}
+	if got, want := problems[0].Line, 2; got != want {
+		t.Errorf("syntax error line got %d, want %d", got, want)
+	}
+}
+
+func TestReadScheduling(t *testing.T) {
+	path := writeConfig(t, `
+projectid = "testproject"
+defaultzone = "us-east1-b"
+defaultuserdata = "#cloud-config"
+
+[instance.spot]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	provisioning = "spot"
+	terminationaction = "delete"
+	maxrunduration = "4h"
+
+[instance.bad]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	provisioning = "preemptible"
+	automaticrestart = true
+
+[instance.worse]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	provisioning = "cheap"
+	terminationaction = "explode"
+	maxrunduration = "forever"
+`)
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Read got error %v, want Problems", err)
+	}
+	got := make([]string, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, p.Message)
+	}
+	want := []string{
+		"instance bad: preemptible nodes can't restart automatically",
+		`instance worse: provisioning "cheap" is not standard, spot or preemptible`,
+		`instance worse: terminationaction "explode" is not stop or delete`,
+		`instance worse: maxrunduration "forever" is not a duration between 30s and 2880h`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadDisks(t *testing.T) {
+	path := writeConfig(t, `
+projectid = "testproject"
+defaultzone = "us-east1-b"
+defaultuserdata = "#cloud-config"
+
+[instance.dev]
+	family = "cos-cloud"
+	hardware = "e2-small"
+
+	[[instance.dev.disk]]
+		name = "{{.Node}}-home"
+		size = 100
+		type = "pd-ssd"
+
+	[[instance.dev.disk]]
+		name = "{{.Node"
+		type = "floppy"
+		mode = "ro"
+		autodelete = true
+`)
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Read got error %v, want Problems", err)
+	}
+	got := make([]string, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, p.Message)
+	}
+	want := []string{
+		`instance dev: disk 2: bad disk name "{{.Node": template: disk:1: unclosed action`,
+		`instance dev: disk 2: type "floppy" is not a disk type like pd-balanced`,
+		"instance dev: disk 2: a read-only disk must reuse an existing disk",
+		"instance dev: disk 2: a read-only disk can't be deleted with the node",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+
+	name, err := DiskConfig{Name: "{{.Node}}-{{.Config}}"}.DiskName("box", "dev")
+	if err != nil {
+		t.Fatalf("DiskName: %v", err)
+	}
+	if want := "box-dev"; name != want {
+		t.Errorf("DiskName got %q, want %q", name, want)
+	}
+}
+
+func TestReadBootDisk(t *testing.T) {
+	path := writeConfig(t, `
+projectid = "testproject"
+defaultzone = "us-east1-b"
+defaultuserdata = "#cloud-config"
+
+[instance.fast]
+	family = "cos-cloud"
+	hardware = "n2-standard-8"
+	disktype = "hyperdisk-balanced"
+	diskiops = 5000
+	diskthroughput = 250
+	localssds = 2
+
+[instance.slow]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	disktype = "pd-ssd"
+	diskiops = 5000
+	localssds = 25
+	localssdmount = "scratch"
+
+[instance.spinning]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	disktype = "floppy"
+`)
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Read got error %v, want Problems", err)
+	}
+	got := make([]string, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, p.Message)
+	}
+	want := []string{
+		"instance slow: diskiops needs a pd-extreme or hyperdisk disktype",
+		"instance slow: localssds 25 is not between 0 and 24",
+		`instance slow: localssdmount "scratch" is not an absolute path`,
+		`instance spinning: disktype "floppy" is not a disk type like pd-balanced`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadNetwork(t *testing.T) {
+	path := writeConfig(t, `
+projectid = "testproject"
+defaultzone = "us-east1-b"
+defaultuserdata = "#cloud-config"
+
+[instance.private]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	network = "dev"
+	subnetwork = "dev-east"
+	tags = ["ssh", "http-server"]
+	externalip = "none"
+	jumphost = "me@bastion.example.com:2222"
+
+[instance.public]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	tags = ["HTTP_Server"]
+	externalip = "elastic"
+	jumphost = "me@bastion@example.com"
+`)
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Read got error %v, want Problems", err)
+	}
+	got := make([]string, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, p.Message)
+	}
+	want := []string{
+		`instance public: tag "HTTP_Server" is not lowercase letters, digits and dashes`,
+		`instance public: externalip "elastic" is not ephemeral, static or none`,
+		`instance public: jumphost "me@bastion@example.com" is not [user@]host[:port]`,
+		`instance public: jumphost needs externalip = "none"`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadServiceAccount(t *testing.T) {
+	path := writeConfig(t, `
+projectid = "testproject"
+defaultzone = "us-east1-b"
+defaultuserdata = "#cloud-config"
+
+[instance.builder]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	serviceaccount = "builder@testproject.iam.gserviceaccount.com"
+	scopes = ["devstorage.read_write", "https://www.googleapis.com/auth/logging.write"]
+
+[instance.isolated]
+	extends = "builder"
+	serviceaccount = "none"
+
+[instance.broken]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	serviceaccount = "builder"
+	scopes = ["Cloud Platform"]
+`)
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Read got error %v, want Problems", err)
+	}
+	type problem struct {
+		Line    int
+		Message string
+	}
+	got := make([]problem, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, problem{p.Line, p.Message})
+	}
+	want := []problem{
+		{12, "instance isolated: scopes need a serviceaccount"},
+		{19, `instance broken: serviceaccount "builder" is not an email address, default or none`},
+		{20, `instance broken: scope "Cloud Platform" is not a URL or a name like devstorage.read_only`},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadLabels(t *testing.T) {
+	path := writeConfig(t, `
+projectid = "testproject"
+defaultzone = "us-east1-b"
+defaultuserdata = "#cloud-config"
+
+[instance.billed]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	labels = { team = "infra", cost-center = "cc_42" }
+
+[instance.broken]
+	family = "cos-cloud"
+	hardware = "e2-small"
+
+	[instance.broken.labels]
+		Team = "infra"
+		gocloud-user = "someone"
+		owner = "Jane Doe"
+`)
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Read got error %v, want Problems", err)
+	}
+	type problem struct {
+		Line    int
+		Message string
+	}
+	got := make([]problem, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, problem{p.Line, p.Message})
+	}
+	want := []problem{
+		{16, `instance broken: label key "Team" is not lowercase letters, digits, dashes and underscores`},
+		{17, `instance broken: label key "gocloud-user" is reserved for gocloud`},
+		{18, `instance broken: label owner value "Jane Doe" is not lowercase letters, digits, dashes and underscores`},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadImages(t *testing.T) {
+	path := writeConfig(t, `
+projectid = "testproject"
+defaultzone = "us-east1-b"
+defaultuserdata = "#cloud-config"
+
+[instance.debian]
+	hardware = "e2-small"
+	imageproject = "debian-cloud"
+	imagefamily = "debian-12"
+
+[instance.baked]
+	hardware = "e2-small"
+	image = "projects/testproject/global/images/baked-20241001"
+
+[instance.ubuntu]
+	hardware = "e2-small"
+	family = "ubuntu-os-cloud"
+
+[instance.both]
+	extends = "debian"
+	image = "Debian 12"
+
+[instance.longterm]
+	hardware = "e2-small"
+	family = "cos-cloud"
+	channel = "lts-105"
+
+[instance.nightly]
+	extends = "longterm"
+	channel = "nightly"
+
+[instance.debianbeta]
+	extends = "debian"
+	channel = "beta"
+`)
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Read got error %v, want Problems", err)
+	}
+	got := make([]string, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, p.Message)
+	}
+	want := []string{
+		"instance ubuntu: set imagefamily or image to choose an image from ubuntu-os-cloud",
+		`instance both: image "Debian 12" is not an image name or projects/<project>/global/images/<name>`,
+		"instance both: set only one of image and imagefamily",
+		`instance nightly: channel "nightly" is not stable, beta, dev, lts or lts-<milestone>`,
+		"instance debianbeta: channel only applies to cos-cloud images chosen without imagefamily or image",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadHostKey(t *testing.T) {
+	path := writeConfig(t, `
+projectid = "testproject"
+defaultzone = "us-east1-b"
+defaultuserdatafile = "cloud-config.yaml"
+
+[instance.unpinned]
+	family = "cos-cloud"
+	hardware = "e2-small"
+
+[instance.pinned]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	hostkey = true
+	userdata = """
+#cloud-config
+bootcmd:
+- curl -sf -H Metadata-Flavor:Google -o /etc/ssh/ssh_host_ed25519_key http://metadata.google.internal/computeMetadata/v1/instance/attributes/sshhostkey
+"""
+
+[instance.forgotten]
+	family = "cos-cloud"
+	hardware = "e2-small"
+	hostkey = true
+`)
+
+	_, err := Read(path)
+	var problems Problems
+	if !errors.As(err, &problems) || len(problems) != 1 {
+		t.Fatalf("Read got error %v, want one Problem", err)
+	}
+	if got, want := problems[0].Message, "instance forgotten: hostkey needs userdata that installs the sshhostkey metadata attribute as the ssh host key"; got != want {
+		t.Errorf("problem got %q, want %q", got, want)
+	}
+	if got, want := problems[0].Line, 23; got != want {
+		t.Errorf("problem line got %d, want %d", got, want)
+	}
+}
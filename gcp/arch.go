@@ -0,0 +1,32 @@
+package gcp
+
+import (
+	"path"
+	"strings"
+)
+
+// armSeries are the machine series with Arm processors.
+var armSeries = map[string]bool{
+	"t2a": true,
+	"c4a": true,
+	"n4a": true,
+}
+
+// machineArch returns the Go architecture name, amd64 or arm64, of the
+// processors of machinetype, which can be a machine type URL.
+func machineArch(machinetype string) string {
+	series, _, _ := strings.Cut(path.Base(machinetype), "-")
+	if armSeries[series] {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// imageArch returns the Compute Engine name of the image architecture
+// that runs on arch.
+func imageArch(arch string) string {
+	if arch == "arm64" {
+		return "ARM64"
+	}
+	return "X86_64"
+}
@@ -0,0 +1,68 @@
+package gcp
+
+import (
+	"testing"
+
+	"github.com/rjkroege/gocloud/config"
+	"github.com/rjkroege/gocloud/gcp/fakecompute"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestMachineArch(t *testing.T) {
+	for _, tc := range []struct{ machinetype, want string }{
+		{"e2-small", "amd64"},
+		{"n2-standard-8", "amd64"},
+		{"t2a-standard-4", "arm64"},
+		{"c4a-highmem-8", "arm64"},
+		{"https://www.googleapis.com/compute/v1/projects/testproject/zones/us-central1-a/machineTypes/c4a-standard-4", "arm64"},
+		{"zones/us-east1-b/machineTypes/t2d-standard-2", "amd64"},
+	} {
+		if got := machineArch(tc.machinetype); got != tc.want {
+			t.Errorf("machineArch(%q) got %s, want %s", tc.machinetype, got, tc.want)
+		}
+	}
+}
+
+func TestMakeNodeArm(t *testing.T) {
+	c, fake := newTestClient(t)
+	fake.AddImages("debian-cloud",
+		&compute.Image{Name: "debian-12-bookworm-v20241009", Family: "debian-12", Architecture: "X86_64"},
+		&compute.Image{Name: "debian-12-bookworm-arm64-v20241009", Family: "debian-12-arm64", Architecture: "ARM64"},
+	)
+	c.settings.InstanceTypes["arm"] = config.InstanceConfig{
+		Extends:  "small",
+		Hardware: "t2a-standard-2",
+	}
+	c.settings.InstanceTypes["armdebian"] = config.InstanceConfig{
+		Hardware:     "c4a-standard-4",
+		ImageProject: "debian-cloud",
+		ImageFamily:  "debian-12-arm64",
+	}
+	c.settings.InstanceTypes["wrongdebian"] = config.InstanceConfig{
+		Extends:     "armdebian",
+		ImageFamily: "debian-12",
+	}
+
+	for _, tc := range []struct {
+		config, want string
+	}{
+		{"arm", "cos-cloud/global/images/cos-arm64-stable-105-17412-156-59"},
+		{"armdebian", "debian-cloud/global/images/debian-12-bookworm-arm64-v20241009"},
+	} {
+		ni, err := c.MakeNode(tc.config, tc.config+"-node")
+		if err != nil {
+			t.Fatalf("MakeNode(%s): %v", tc.config, err)
+		}
+		if got, want := ni.Arch, "arm64"; got != want {
+			t.Errorf("%s: NodeInfo.Arch got %s, want %s", tc.config, got, want)
+		}
+		inst := fake.Instance("us-east1-b", tc.config+"-node")
+		if got, want := inst.Disks[0].InitializeParams.SourceImage, fakecompute.LinkPrefix+tc.want; got != want {
+			t.Errorf("%s: SourceImage got %q, want %q", tc.config, got, want)
+		}
+	}
+
+	if _, err := c.MakeNode("wrongdebian", "wrong-node"); err == nil {
+		t.Error("MakeNode of an Arm machine with an x86 image should fail")
+	}
+}
@@ -4,14 +4,15 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"regexp"
 
 	"github.com/rjkroege/gocloud/config"
-	"github.com/sanity-io/litter"
 	compute "google.golang.org/api/compute/v1"
 )
 
@@ -34,10 +35,30 @@ func convertMapToGcpFormat(metas map[string]string) *compute.Metadata {
 	}
 }
 
-// makeMetadataObject makes a Go map of metadata key-value pairs.
+// configNameKey is the metadata key recording the gocloud configuration
+// that a node was made from.
+const configNameKey = "gocloudconfig"
+
+// secretMetadataKeys are the metadata keys whose values describing a node
+// or showing the metadata for a configuration redacts by default.
+var secretMetadataKeys = map[string]bool{
+	"instancetoken":     true,
+	hostKeyMetadataKey:  true,
+	"rcloneconfig":      true,
+	"kopiareconnection": true,
+	"user-data":         true,
+}
+
+// makeMetadataObject makes a Go map of metadata key-value pairs. It
+// writes why optional attributes are missing to notes unless notes is
+// nil.
 // TODO(rjk): In a cpu-aware world, additional settings can be removed.
-func makeMetadataObject(settings *config.Settings, configName string) (map[string]string, error) {
+func makeMetadataObject(settings *config.Settings, configName string, notes io.Writer) (map[string]string, error) {
 	metas := make(map[string]string)
+	ic, err := settings.Instance(configName)
+	if err != nil {
+		return nil, err
+	}
 
 	// username
 	userinfo, err := user.Current()
@@ -46,6 +67,8 @@ func makeMetadataObject(settings *config.Settings, configName string) (map[strin
 	}
 	metas["username"] = string(userinfo.Username)
 
+	metas[configNameKey] = configName
+
 	// unique token identifying this node
 	rawtoken := make([]byte, 16)
 	_, err = rand.Read(rawtoken)
@@ -55,8 +78,16 @@ func makeMetadataObject(settings *config.Settings, configName string) (map[strin
 	// There can be nulls in token so encode.
 	metas["instancetoken"] = base64.StdEncoding.EncodeToString(rawtoken)
 
+	// ssh host key, pinned in the local ssh configuration.
+	if ic.HostKey {
+		metas[hostKeyMetadataKey], err = makeHostKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// githost, read from the configuration file.
-	githost := settings.InstanceTypes[configName].GitHost
+	githost := ic.GitHost
 	if githost != "" {
 		metas["githost"] = githost
 	}
@@ -72,20 +103,26 @@ func makeMetadataObject(settings *config.Settings, configName string) (map[strin
 	// Ship rclone configuration to the client if it exists.
 	rclonepath := filepath.Join(userinfo.HomeDir, ".config", "rclone", "rclone.conf")
 	if rclonekey, err := ioutil.ReadFile(rclonepath); err != nil {
-		fmt.Printf("not adding rclone config to instance metadata because can't read rclone config %q: %v", rclonepath, err)
+		notef(notes, "not adding rclone config to instance metadata because can't read rclone config %q: %v\n", rclonepath, err)
 	} else {
 		metas["rcloneconfig"] = string(rclonekey)
 	}
 
-	userdata := settings.UserData(configName)
+	userdata, err := settings.UserData(configName)
+	if err != nil {
+		return nil, err
+	}
 	if userdata == "" {
 		return nil, fmt.Errorf("userdata must be set")
 	}
+	if userdata, err = addLocalSsdBootCmds(userdata, ic); err != nil {
+		return nil, err
+	}
 	metas["user-data"] = userdata
 
 	// Insert the kopia connect restoration code.
 	if kopiaauth, err := readKopiaConfiguration(); err != nil {
-		fmt.Errorf("not adding kopia reconnection string to instance metadata because: %v", err)
+		notef(notes, "not adding kopia reconnection string to instance metadata because: %v\n", err)
 	} else {
 		metas["kopiareconnection"] = kopiaauth
 	}
@@ -93,16 +130,33 @@ func makeMetadataObject(settings *config.Settings, configName string) (map[strin
 	return metas, nil
 }
 
-// ShowMetadata will display the metadata object.
-func ShowMetadata(settings *config.Settings, configName string) error {
-	metadata, err := makeMetadataObject(settings, configName)
+// notef writes a note to w unless w is nil.
+func notef(w io.Writer, format string, args ...interface{}) {
+	if w != nil {
+		fmt.Fprintf(w, format, args...)
+	}
+}
+
+// ShowMetadata will display the metadata object in format. Notes about
+// missing optional attributes go to stderr so that they don't corrupt
+// json or yaml output. Secret values are redacted unless showsecrets is
+// set.
+func ShowMetadata(settings *config.Settings, configName string, format *Format, showsecrets bool) error {
+	metadata, err := makeMetadataObject(settings, configName, os.Stderr)
 	if err != nil {
 		return err
 	}
+	if !showsecrets {
+		for k := range metadata {
+			if secretMetadataKeys[k] {
+				metadata[k] = redacted
+			}
+		}
+	}
 
-	litter.Dump(metadata)
-	litter.Dump(convertMapToGcpFormat(metadata))
-	return nil
+	return format.write(os.Stdout, metadata, func(w io.Writer) error {
+		return writeMetadataTable(w, metadata)
+	})
 }
 
 // readKopiaConfiguration runs kopia to get a reconnection string.
@@ -0,0 +1,101 @@
+package gcp
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// serialTailLines is how many lines of a failed node's serial console
+// CleanUpFailedNode shows.
+const serialTailLines = 40
+
+// CleanUpFailedNode cleans up after a failure to make and configure the
+// node called name from the configuration configName. It writes the tail
+// of the node's serial console to w to help diagnose the failure and
+// then deletes the node, its new data disks and its static address,
+// unless keep is set. Call it only when MakeNode inserted the node, i.e.
+// didn't return a *NotMadeError, so that it never deletes a node that
+// was already there. Use a Client whose context isn't the one that was
+// cancelled to interrupt making the node.
+func (c *Client) CleanUpFailedNode(w io.Writer, configName, name string, keep bool) error {
+	zone := c.settings.Zone(configName)
+	inst, err := c.service.Instances.Get(c.ProjectId, zone, name).Context(c.ctx).Do()
+	if isNotFound(err) {
+		// The insert failed or never happened but the static address
+		// may have been reserved.
+		if keep {
+			return nil
+		}
+		region, err := zoneRegion(zone)
+		if err != nil {
+			return err
+		}
+		return c.releaseAddress(region, name)
+	}
+	if err != nil {
+		return fmt.Errorf("can't get %s: %v", name, err)
+	}
+
+	out, err := c.service.Instances.GetSerialPortOutput(c.ProjectId, zone, name).Port(1).Context(c.ctx).Do()
+	if err != nil {
+		fmt.Fprintf(w, "can't get the serial console output of %s: %v\n", name, err)
+	} else {
+		fmt.Fprintf(w, "last lines of the serial console of %s:\n%s\n", name, serialTail(out.Contents, serialTailLines))
+	}
+
+	if keep {
+		fmt.Fprintf(w, "keeping %s (%s) for debugging: remove it with gocloud del %s\n", name, inst.Status, name)
+		return nil
+	}
+	fmt.Fprintf(w, "deleting %s\n", name)
+	if err := c.Delete(zone, name); err != nil {
+		return err
+	}
+	return c.deleteNewDataDisks(w, zone, configName, name, nil)
+}
+
+// deleteNewDataDisks deletes the data disks of the deleted node called
+// name in zone that outlive it and that making it from the configuration
+// configName created, except for those in keep. The others either went
+// with the node or are reused disks that a new attempt to make the node
+// attaches again.
+func (c *Client) deleteNewDataDisks(w io.Writer, zone, configName, name string, keep map[string]bool) error {
+	ic, err := c.settings.Instance(configName)
+	if err != nil {
+		return err
+	}
+	for _, dc := range ic.Disks {
+		if dc.AutoDelete || dc.Reuse {
+			continue
+		}
+		disk, err := dc.DiskName(name, configName)
+		if err != nil {
+			return err
+		}
+		if keep[disk] {
+			continue
+		}
+		op, err := c.service.Disks.Delete(c.ProjectId, zone, disk).Context(c.ctx).Do()
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("can't delete disk %s: %v", disk, err)
+		}
+		fmt.Fprintf(w, "deleting disk %s\n", disk)
+		if err := c.waitForOperation(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serialTail returns the last n lines of contents.
+func serialTail(contents string, n int) string {
+	lines := strings.Split(strings.TrimRight(contents, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,109 @@
+package gcp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rjkroege/gocloud/config"
+)
+
+func TestCleanUpFailedNode(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["server"] = config.InstanceConfig{
+		Extends:    "small",
+		ExternalIp: "static",
+		Disks: []config.DiskConfig{
+			{Name: "{{.Node}}-data", Size: 10},
+			{Name: "{{.Node}}-scratch", Size: 10, AutoDelete: true},
+			{Name: "shared", Size: 10, Reuse: true},
+		},
+	}
+
+	var console strings.Builder
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&console, "boot line %d\n", i)
+	}
+
+	for _, keep := range []bool{true, false} {
+		if _, err := c.MakeNode("server", "broken"); err != nil {
+			t.Fatalf("MakeNode: %v", err)
+		}
+		fake.SetSerialPortOutput("us-east1-b", "broken", console.String())
+
+		var buf bytes.Buffer
+		if err := c.CleanUpFailedNode(&buf, "server", "broken", keep); err != nil {
+			t.Fatalf("CleanUpFailedNode(keep=%v): %v", keep, err)
+		}
+		out := buf.String()
+		if !strings.Contains(out, "boot line 11\n") || !strings.Contains(out, "boot line 50\n") {
+			t.Errorf("CleanUpFailedNode(keep=%v) didn't show the serial console tail:\n%s", keep, out)
+		}
+		if strings.Contains(out, "boot line 10\n") {
+			t.Errorf("CleanUpFailedNode(keep=%v) showed more than %d lines:\n%s", keep, serialTailLines, out)
+		}
+
+		inst := fake.Instance("us-east1-b", "broken")
+		addr := fake.Address("us-east1", "broken-ip")
+		data := fake.Disk("us-east1-b", "broken-data")
+		if keep {
+			if inst == nil || addr == nil || data == nil {
+				t.Fatal("CleanUpFailedNode with keep removed the node")
+			}
+			if err := c.Delete("us-east1-b", "broken"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if err := c.deleteNewDataDisks(&buf, "us-east1-b", "server", "broken", nil); err != nil {
+				t.Fatalf("deleteNewDataDisks: %v", err)
+			}
+			continue
+		}
+		if inst != nil {
+			t.Error("CleanUpFailedNode didn't delete the node")
+		}
+		if addr != nil {
+			t.Error("CleanUpFailedNode didn't release the static address")
+		}
+		if data != nil {
+			t.Errorf("CleanUpFailedNode didn't delete the new data disk:\n%s", out)
+		}
+		if fake.Disk("us-east1-b", "shared") == nil {
+			t.Errorf("CleanUpFailedNode deleted the reusable disk:\n%s", out)
+		}
+	}
+
+	// The new data disk doesn't stop the node from being made again.
+	if _, err := c.MakeNode("server", "broken"); err != nil {
+		t.Fatalf("MakeNode after CleanUpFailedNode: %v", err)
+	}
+}
+
+func TestMakeNodeNotMade(t *testing.T) {
+	c, fake := newTestClient(t)
+	if _, err := c.MakeNode("small", "existing"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+
+	fake.FailInsert("QUOTA_EXCEEDED", "Quota 'CPUS' exceeded.")
+	for _, name := range []string{"broken", "existing"} {
+		_, err := c.MakeNode("small", name)
+		var notmade *NotMadeError
+		if !errors.As(err, &notmade) {
+			t.Errorf("MakeNode(%s) got error %v, want a NotMadeError", name, err)
+		}
+	}
+	c.settings.InstanceTypes["nodisk"] = config.InstanceConfig{
+		Extends: "small",
+		Disks:   []config.DiskConfig{{Name: "{{.Nod}}"}},
+	}
+	_, err := c.MakeNode("nodisk", "broken")
+	var notmade *NotMadeError
+	if !errors.As(err, &notmade) {
+		t.Errorf("MakeNode with a bad disk name got error %v, want a NotMadeError", err)
+	}
+	if fake.Instance("us-east1-b", "existing") == nil {
+		t.Error("the failed MakeNode removed the existing node")
+	}
+}
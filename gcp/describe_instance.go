@@ -1,49 +1,162 @@
 package gcp
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/rjkroege/gocloud/config"
-	"github.com/sanity-io/litter"
 	compute "google.golang.org/api/compute/v1"
 )
 
-// DescribeInstance describes instance |name| with a sump of its JSON
-// description.
-func DescribeInstance(settings *config.Settings, name string) error {
-	instance, err := getInstance(settings, name)
+// NodeDescription describes a node in more detail than Node. Its json
+// and yaml representations are a stable interface for scripts. The
+// node's labels are those of the embedded Node.
+type NodeDescription struct {
+	Node `yaml:",inline"`
+
+	Id              string            `json:"id" yaml:"id"`
+	Description     string            `json:"description" yaml:"description"`
+	InternalAddr    string            `json:"internalAddr" yaml:"internalAddr"`
+	Disks           []DiskDescription `json:"disks" yaml:"disks"`
+	ServiceAccounts []string          `json:"serviceAccounts" yaml:"serviceAccounts"`
+	Tags            []string          `json:"tags" yaml:"tags"`
+	Metadata        map[string]string `json:"metadata" yaml:"metadata"`
+}
+
+// DiskDescription describes a disk attached to a node.
+type DiskDescription struct {
+	Name       string `json:"name" yaml:"name"`
+	SizeGb     int64  `json:"sizeGb" yaml:"sizeGb"`
+	Type       string `json:"type" yaml:"type"`
+	Mode       string `json:"mode" yaml:"mode"`
+	Boot       bool   `json:"boot" yaml:"boot"`
+	AutoDelete bool   `json:"autoDelete" yaml:"autoDelete"`
+}
+
+// redacted replaces the values of secret metadata keys.
+const redacted = "<redacted>"
+
+// describeInstance makes a NodeDescription of inst. The values of the
+// secretMetadataKeys are redacted unless showsecrets is set.
+func describeInstance(inst *compute.Instance, showsecrets bool) *NodeDescription {
+	nd := &NodeDescription{
+		Node:        *summarizeInstance(inst),
+		Id:          strconv.FormatUint(inst.Id, 10),
+		Description: inst.Description,
+		Metadata:    make(map[string]string),
+	}
+
+	for _, ni := range inst.NetworkInterfaces {
+		if ni.NetworkIP != "" {
+			nd.InternalAddr = ni.NetworkIP
+			break
+		}
+	}
+	for _, d := range inst.Disks {
+		nd.Disks = append(nd.Disks, DiskDescription{
+			Name:       path.Base(d.Source),
+			SizeGb:     d.DiskSizeGb,
+			Type:       d.Type,
+			Mode:       d.Mode,
+			Boot:       d.Boot,
+			AutoDelete: d.AutoDelete,
+		})
+	}
+	for _, sa := range inst.ServiceAccounts {
+		nd.ServiceAccounts = append(nd.ServiceAccounts, sa.Email)
+	}
+	if inst.Tags != nil {
+		nd.Tags = inst.Tags.Items
+	}
+	if inst.Metadata != nil {
+		for _, it := range inst.Metadata.Items {
+			switch {
+			case it.Value == nil:
+				nd.Metadata[it.Key] = ""
+			case secretMetadataKeys[it.Key] && !showsecrets:
+				nd.Metadata[it.Key] = redacted
+			default:
+				nd.Metadata[it.Key] = *it.Value
+			}
+		}
+	}
+	return nd
+}
+
+// DescribeInstance describes instance |name| in zone in format. An empty
+// zone means the zone that contains the instance. Secret metadata values
+// are redacted unless showsecrets is set.
+func DescribeInstance(settings *config.Settings, zone, name string, format *Format, showsecrets bool) error {
+	c, err := NewClient(context.Background(), settings)
 	if err != nil {
 		return err
 	}
 
-	litter.Dump(instance)
-	//	litter.Dump(pullKeys(instance))
+	instance, err := c.Describe(zone, name)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	nd := describeInstance(instance, showsecrets)
+	return format.write(os.Stdout, nd, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "name:\t%s\n", nd.Name)
+		fmt.Fprintf(tw, "id:\t%s\n", nd.Id)
+		fmt.Fprintf(tw, "zone:\t%s\n", nd.Zone)
+		fmt.Fprintf(tw, "status:\t%s\n", nd.Status)
+		fmt.Fprintf(tw, "machine:\t%s\n", nd.MachineType)
+		fmt.Fprintf(tw, "created:\t%s\n", formatCreated(nd.Created))
+		fmt.Fprintf(tw, "config:\t%s\n", orDash(nd.ConfigName))
+		fmt.Fprintf(tw, "description:\t%s\n", orDash(nd.Description))
+		fmt.Fprintf(tw, "addr:\t%s\n", orDash(nd.Addr))
+		fmt.Fprintf(tw, "internal addr:\t%s\n", orDash(nd.InternalAddr))
+		for _, d := range nd.Disks {
+			fmt.Fprintf(tw, "disk:\t%s %dGB %s %s boot=%v autodelete=%v\n", d.Name, d.SizeGb, d.Type, d.Mode, d.Boot, d.AutoDelete)
+		}
+		fmt.Fprintf(tw, "service accounts:\t%s\n", orDash(strings.Join(nd.ServiceAccounts, " ")))
+		fmt.Fprintf(tw, "tags:\t%s\n", orDash(strings.Join(nd.Tags, " ")))
+		for _, k := range config.SortedKeys(nd.Labels) {
+			fmt.Fprintf(tw, "label:\t%s=%s\n", k, nd.Labels[k])
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		return writeMetadataTable(w, nd.Metadata)
+	})
 }
 
-func getInstance(settings *config.Settings, name string) (*compute.Instance, error) {
-	_, client, err := NewAuthenticatedClient([]string{
-		compute.ComputeScope,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("NewAuthenticatedClient failed: %v", err)
+// writeMetadataTable writes metadata to w as a sorted list of keys each
+// followed by its (indented) value.
+func writeMetadataTable(w io.Writer, metadata map[string]string) error {
+	for _, k := range config.SortedKeys(metadata) {
+		v := strings.TrimRight(metadata[k], "\n")
+		if _, err := fmt.Fprintf(w, "metadata %s:\n\t%s\n", k, strings.ReplaceAll(v, "\n", "\n\t")); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	service, err := compute.New(client)
+// Describe returns the instance called name in zone. An empty zone
+// means the zone that contains the instance.
+func (c *Client) Describe(zone, name string) (*compute.Instance, error) {
+	zone, err := c.resolveZone(zone, name)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to create Compute service: %v", err)
+		return nil, err
 	}
 
-	projectId := settings.ProjectId
-	zone := settings.DefaultZone
-
 	// It is impossible to filter to a single metadata element. I can however
 	// shrink the total response size by just asking for the metadata key
 	// strings. I find this limitation perplexing. This is what the Fields() method
 	// does. Accept it.
 	//	instance, err := service.Instances.Get(projectId, zone, name).Fields("metadata/items/key").Do()
-	instance, err := service.Instances.Get(projectId, zone, name).Do()
+	instance, err := c.service.Instances.Get(c.ProjectId, zone, name).Context(c.ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("getting instance failed: %v", err)
 	}
@@ -59,7 +172,12 @@ func pullKeys(instance *compute.Instance) []string {
 }
 
 func GetMetadataKeys(settings *config.Settings, name string) ([]string, error) {
-	instance, err := getInstance(settings, name)
+	c, err := NewClient(context.Background(), settings)
+	if err != nil {
+		return []string{}, err
+	}
+
+	instance, err := c.Describe("", name)
 	if err != nil {
 		return []string{}, err
 	}
@@ -0,0 +1,31 @@
+package gcp
+
+import (
+	"testing"
+)
+
+func TestDescribeRedacts(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	ni, err := c.MakeNode("small", "secretive")
+	if err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	inst, err := c.Describe("", "secretive")
+	if err != nil {
+		t.Fatalf("Describe: %v", err)
+	}
+
+	nd := describeInstance(inst, false)
+	if got, want := nd.Metadata["instancetoken"], redacted; got != want {
+		t.Errorf("instancetoken got %q, want %q", got, want)
+	}
+	if got, want := nd.Metadata[configNameKey], "small"; got != want {
+		t.Errorf("%s got %q, want %q", configNameKey, got, want)
+	}
+
+	nd = describeInstance(inst, true)
+	if got, want := nd.Metadata["instancetoken"], ni.Token; got != want {
+		t.Errorf("instancetoken with showsecrets got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,188 @@
+package gcp
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// DiskOptions control how AttachDisk attaches a disk.
+type DiskOptions struct {
+	ReadOnly bool
+
+	// AutoDelete deletes the disk when the node is deleted.
+	AutoDelete bool
+
+	// SizeGb is the size of the disk to make if it doesn't exist. Zero
+	// means that the disk must exist.
+	SizeGb int64
+
+	// Type is the disk type of a new disk, e.g. pd-ssd. Empty means
+	// pd-balanced.
+	Type string
+}
+
+// defaultDiskType is the type of new data disks.
+const defaultDiskType = "pd-balanced"
+
+// diskTypeURL returns the URL of the disk type dt in zone.
+func (c *Client) diskTypeURL(zone, dt string) string {
+	if dt == "" {
+		dt = defaultDiskType
+	}
+	return "https://www.googleapis.com/compute/v1/projects/" + c.ProjectId + "/zones/" + zone + "/diskTypes/" + dt
+}
+
+// findDisk returns the disk called name in zone or nil if there's no
+// such disk.
+func (c *Client) findDisk(zone, name string) (*compute.Disk, error) {
+	disk, err := c.service.Disks.Get(c.ProjectId, zone, name).Context(c.ctx).Do()
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't get disk %s: %v", name, err)
+	}
+	return disk, nil
+}
+
+// isNotFound returns true if err is a Compute Engine API error for a
+// missing resource.
+func isNotFound(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && gerr.Code == http.StatusNotFound
+}
+
+// isAlreadyExists returns true if err is a Compute Engine API error for
+// making a resource that exists.
+func isAlreadyExists(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && gerr.Code == http.StatusConflict
+}
+
+// dataDisks makes the data disks of the configuration ic for the new
+// node called instanceName in zone. Each disk's device name is its disk
+// name.
+func (c *Client) dataDisks(zone, configName, instanceName string, ic config.InstanceConfig) ([]*compute.AttachedDisk, error) {
+	disks := make([]*compute.AttachedDisk, 0, len(ic.Disks))
+	for _, dc := range ic.Disks {
+		name, err := dc.DiskName(instanceName, configName)
+		if err != nil {
+			return nil, err
+		}
+		ad := &compute.AttachedDisk{
+			AutoDelete: dc.AutoDelete,
+			DeviceName: name,
+			Mode:       "READ_WRITE",
+			Type:       "PERSISTENT",
+		}
+		if dc.Mode == "ro" {
+			ad.Mode = "READ_ONLY"
+		}
+
+		var existing *compute.Disk
+		if dc.Reuse {
+			if existing, err = c.findDisk(zone, name); err != nil {
+				return nil, err
+			}
+		}
+		if existing != nil {
+			ad.Source = existing.SelfLink
+		} else {
+			ad.InitializeParams = &compute.AttachedDiskInitializeParams{
+				DiskName:   name,
+				DiskSizeGb: dc.Size,
+				DiskType:   c.diskTypeURL(zone, dc.Type),
+			}
+		}
+		disks = append(disks, ad)
+	}
+	return disks, nil
+}
+
+// AttachDisk attaches the disk called disk to the node called name in
+// zone, making the disk first if it doesn't exist and opts.SizeGb is set.
+// An empty zone means the zone that contains the node. The node's device
+// name for the disk is the disk's name.
+func (c *Client) AttachDisk(zone, name, disk string, opts DiskOptions) error {
+	zone, err := c.resolveZone(zone, name)
+	if err != nil {
+		return err
+	}
+
+	d, err := c.findDisk(zone, disk)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		if opts.SizeGb == 0 {
+			return fmt.Errorf("disk %s doesn't exist in %s: give a size to make it", disk, zone)
+		}
+		op, err := c.service.Disks.Insert(c.ProjectId, zone, &compute.Disk{
+			Name:   disk,
+			SizeGb: opts.SizeGb,
+			Type:   c.diskTypeURL(zone, opts.Type),
+		}).Context(c.ctx).Do()
+		if err != nil {
+			return fmt.Errorf("can't make disk %s: %v", disk, err)
+		}
+		if err := c.waitForOperation(op); err != nil {
+			return err
+		}
+		if d, err = c.findDisk(zone, disk); err != nil {
+			return err
+		}
+		if d == nil {
+			return fmt.Errorf("disk %s vanished after it was made", disk)
+		}
+	}
+
+	ad := &compute.AttachedDisk{
+		AutoDelete: opts.AutoDelete,
+		DeviceName: disk,
+		Mode:       "READ_WRITE",
+		Source:     d.SelfLink,
+		Type:       "PERSISTENT",
+	}
+	if opts.ReadOnly {
+		ad.Mode = "READ_ONLY"
+	}
+	op, err := c.service.Instances.AttachDisk(c.ProjectId, zone, name, ad).Context(c.ctx).Do()
+	if err != nil {
+		return fmt.Errorf("can't attach %s to %s: %v", disk, name, err)
+	}
+	return c.waitForOperation(op)
+}
+
+// DetachDisk detaches the disk called disk, given by disk name or
+// device name, from the node called name in zone. The disk itself is
+// kept. An empty zone means the zone that contains the node.
+func (c *Client) DetachDisk(zone, name, disk string) error {
+	zone, err := c.resolveZone(zone, name)
+	if err != nil {
+		return err
+	}
+	inst, err := c.Describe(zone, name)
+	if err != nil {
+		return err
+	}
+
+	for _, ad := range inst.Disks {
+		if ad.DeviceName != disk && path.Base(ad.Source) != disk {
+			continue
+		}
+		if ad.Boot {
+			return fmt.Errorf("%s is the boot disk of %s", disk, name)
+		}
+		op, err := c.service.Instances.DetachDisk(c.ProjectId, zone, name, ad.DeviceName).Context(c.ctx).Do()
+		if err != nil {
+			return fmt.Errorf("can't detach %s from %s: %v", disk, name, err)
+		}
+		return c.waitForOperation(op)
+	}
+	return fmt.Errorf("%s has no disk %s", name, disk)
+}
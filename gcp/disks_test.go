@@ -0,0 +1,106 @@
+package gcp
+
+import (
+	"path"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/gocloud/config"
+)
+
+// attachedDisks returns the names of the disks attached to the node
+// called name in zone.
+func attachedDisks(t *testing.T, c *Client, zone, name string) []string {
+	t.Helper()
+	inst, err := c.Describe(zone, name)
+	if err != nil {
+		t.Fatalf("Describe: %v", err)
+	}
+	names := make([]string, 0, len(inst.Disks))
+	for _, ad := range inst.Disks {
+		names = append(names, path.Base(ad.Source))
+	}
+	return names
+}
+
+func TestMakeNodeDisks(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["dev"] = config.InstanceConfig{
+		Extends: "small",
+		Disks: []config.DiskConfig{
+			{Name: "{{.Node}}-home", Size: 100, Type: "pd-ssd", Reuse: true},
+			{Name: "{{.Node}}-scratch", Size: 50, AutoDelete: true},
+		},
+	}
+
+	if _, err := c.MakeNode("dev", "box"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	if diff := cmp.Diff([]string{"box-root", "box-home", "box-scratch"}, attachedDisks(t, c, "us-east1-b", "box")); diff != "" {
+		t.Errorf("MakeNode disks mismatch (-want +got):\n%s", diff)
+	}
+	home := fake.Disk("us-east1-b", "box-home")
+	if home == nil {
+		t.Fatal("MakeNode didn't make box-home")
+	}
+	if got, want := home.SizeGb, int64(100); got != want {
+		t.Errorf("box-home size got %d, want %d", got, want)
+	}
+	if got, want := path.Base(home.Type), "pd-ssd"; got != want {
+		t.Errorf("box-home type got %s, want %s", got, want)
+	}
+
+	if err := c.Delete("", "box"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if fake.Disk("us-east1-b", "box-home") == nil {
+		t.Error("Delete removed box-home, which should outlive the node")
+	}
+	if fake.Disk("us-east1-b", "box-scratch") != nil {
+		t.Error("Delete kept box-scratch, which should be deleted with the node")
+	}
+
+	// Remaking the node reuses its home disk.
+	if _, err := c.MakeNode("dev", "box"); err != nil {
+		t.Fatalf("MakeNode again: %v", err)
+	}
+	if got, want := fake.Disk("us-east1-b", "box-home").Id, home.Id; got != want {
+		t.Errorf("MakeNode again made a new box-home (id %d), want the old one (id %d)", got, want)
+	}
+}
+
+func TestAttachDetachDisk(t *testing.T) {
+	c, fake := newTestClient(t)
+	if _, err := c.MakeNode("western", "worker"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+
+	if err := c.AttachDisk("", "worker", "data", DiskOptions{}); err == nil {
+		t.Error("AttachDisk of a missing disk without a size should fail")
+	}
+	if err := c.AttachDisk("", "worker", "data", DiskOptions{SizeGb: 200}); err != nil {
+		t.Fatalf("AttachDisk: %v", err)
+	}
+	if diff := cmp.Diff([]string{"worker-root", "data"}, attachedDisks(t, c, "us-west1-a", "worker")); diff != "" {
+		t.Errorf("AttachDisk disks mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := path.Base(fake.Disk("us-west1-a", "data").Type), defaultDiskType; got != want {
+		t.Errorf("AttachDisk made a disk of type %s, want %s", got, want)
+	}
+
+	if err := c.DetachDisk("", "worker", "worker-root"); err == nil {
+		t.Error("DetachDisk of the boot disk should fail")
+	}
+	if err := c.DetachDisk("", "worker", "data"); err != nil {
+		t.Fatalf("DetachDisk: %v", err)
+	}
+	if diff := cmp.Diff([]string{"worker-root"}, attachedDisks(t, c, "us-west1-a", "worker")); diff != "" {
+		t.Errorf("DetachDisk disks mismatch (-want +got):\n%s", diff)
+	}
+	if fake.Disk("us-west1-a", "data") == nil {
+		t.Error("DetachDisk deleted the disk")
+	}
+	if err := c.DetachDisk("", "worker", "data"); err == nil {
+		t.Error("DetachDisk of a detached disk should fail")
+	}
+}
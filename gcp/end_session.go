@@ -1,29 +1,28 @@
 package gcp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/rjkroege/gocloud/config"
-	compute "google.golang.org/api/compute/v1"
 )
 
-// TODO(rjk): Consider making this block until the instance is actually gone.
-func EndSession(settings *config.Settings, instance string) error {
-	_, client, err := NewAuthenticatedClient([]string{
-		compute.ComputeScope,
-	})
+// EndSession deletes instance from zone and waits for it to be gone. An
+// empty zone means the zone that contains the instance. It then removes
+// the ssh alias and other local ssh state for the instance. On a GCE
+// node, EndSession deletes the node itself.
+func EndSession(settings *config.Settings, zone, instance string) error {
+	c, err := NewClient(context.Background(), settings)
 	if err != nil {
-		return fmt.Errorf("NewAuthenticatedClient failed: %v", err)
+		return err
 	}
-
-	projectid := settings.ProjectId
-	// TODO(rjk): Doesn't handle being outside of the default zone.
-	// TODO(rjk): zone handling needs to be addressed better.
-	zone := settings.DefaultZone
+	c.Progress = os.Stdout
 
 	if metadata.OnGCE() {
-		projectid, err = metadata.ProjectID()
+		c.ProjectId, err = metadata.ProjectID()
 		if err != nil {
 			return fmt.Errorf("couldn't fetch the projectid because %v", err)
 		}
@@ -37,16 +36,51 @@ func EndSession(settings *config.Settings, instance string) error {
 		if err != nil {
 			return fmt.Errorf("couldn't fetch the instance because %v", err)
 		}
+
+		// The node has no local state for itself. It can't release its
+		// static address either, because the address is in use until the
+		// node is gone: gocloud del of the node releases it afterwards.
+		return c.Delete(zone, instance)
+	}
+
+	if err := c.Delete(zone, instance); err != nil {
+		return err
 	}
+	if err := config.RemoveSshAlias(instance); err != nil {
+		return fmt.Errorf("deleted %s but can't clean up its ssh configuration: %v", instance, err)
+	}
+	return nil
+}
 
-	service, err := compute.New(client)
+// Delete deletes the instance called name from zone and waits for it to
+// be gone. An empty zone means the zone that contains the instance. It
+// then releases the static address that gocloud reserved for the
+// instance, if any. When the instance is already gone, for example
+// because it deleted itself, Delete releases the address that it left
+// behind.
+func (c *Client) Delete(zone, name string) error {
+	zone, err := c.resolveZone(zone, name)
+	var noinst *noInstanceError
+	if errors.As(err, &noinst) {
+		return c.releaseLeftAddress(name, err)
+	}
 	if err != nil {
-		return fmt.Errorf("Unable to create Compute service: %v", err)
+		return err
 	}
 
-	_, err = service.Instances.Delete(projectid, zone, instance).Do()
+	op, err := c.service.Instances.Delete(c.ProjectId, zone, name).Context(c.ctx).Do()
+	if isNotFound(err) {
+		return c.releaseLeftAddress(name, fmt.Errorf("no instance %s in zone %s", name, zone))
+	}
 	if err != nil {
-		return fmt.Errorf("Failed to delete instance %s because %v", instance, err)
+		return fmt.Errorf("Failed to delete instance %s because %v", name, err)
 	}
-	return nil
+	if err := c.waitForOperation(op); err != nil {
+		return err
+	}
+	region, err := zoneRegion(zone)
+	if err != nil {
+		return err
+	}
+	return c.releaseAddress(region, name)
 }
@@ -0,0 +1,57 @@
+package gcp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rjkroege/gocloud/config"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestEndSession(t *testing.T) {
+	c, fake := newTestClient(t)
+	fake.AddInstance("us-west1-a", &compute.Instance{Name: "doomed"})
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := config.AddSshAlias("", "doomed", "192.0.2.9", "", nil); err != nil {
+		t.Fatalf("AddSshAlias: %v", err)
+	}
+
+	if err := EndSession(c.settings, "", "doomed"); err != nil {
+		t.Fatalf("EndSession: %v", err)
+	}
+	if fake.Instance("us-west1-a", "doomed") != nil {
+		t.Error("EndSession didn't delete the instance")
+	}
+	sshconfig, err := os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		t.Fatalf("can't read ssh config: %v", err)
+	}
+	if len(sshconfig) != 0 {
+		t.Errorf("EndSession left the ssh alias:\n%s", sshconfig)
+	}
+
+	if err := EndSession(c.settings, "", "doomed"); err == nil {
+		t.Error("EndSession of a missing instance should fail")
+	}
+}
+
+func TestDeleteExplicitZone(t *testing.T) {
+	c, fake := newTestClient(t)
+	fake.AddInstance("us-east1-b", &compute.Instance{Name: "twin"})
+	fake.AddInstance("us-west1-a", &compute.Instance{Name: "twin"})
+
+	if err := c.Delete("", "twin"); err == nil {
+		t.Fatal("Delete of an ambiguous name should fail")
+	}
+
+	if err := c.Delete("us-west1-a", "twin"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if fake.Instance("us-west1-a", "twin") != nil || fake.Instance("us-east1-b", "twin") == nil {
+		t.Error("Delete removed the wrong instance")
+	}
+}
@@ -0,0 +1,114 @@
+package gcp
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// ExistingNode returns the instance called name if there is one, so that
+// gocloud make can stop before it does any work. The returned string
+// says how the instance differs from what MakeNode would make from the
+// configuration configName or is empty if it matches. ExistingNode
+// returns a nil instance if there's no node called name.
+func (c *Client) ExistingNode(configName, name string) (*compute.Instance, string, error) {
+	ic, err := c.settings.Instance(configName)
+	if err != nil {
+		return nil, "", err
+	}
+	insts, err := c.findInstances(name)
+	if err != nil {
+		return nil, "", err
+	}
+	switch len(insts) {
+	case 0:
+		return nil, "", nil
+	case 1:
+	default:
+		zones := make([]string, 0, len(insts))
+		for _, inst := range insts {
+			zones = append(zones, path.Base(inst.Zone))
+		}
+		sort.Strings(zones)
+		return nil, "", fmt.Errorf("%s already exists in zones %s", name, strings.Join(zones, ", "))
+	}
+
+	inst := insts[0]
+	switch got := instanceConfigName(inst); {
+	case got == "":
+		return inst, "it wasn't made by gocloud", nil
+	case got != configName:
+		return inst, fmt.Sprintf("it was made from configuration %s, not %s", got, configName), nil
+	}
+	if got, want := path.Base(inst.Zone), c.settings.Zone(configName); got != want {
+		return inst, fmt.Sprintf("it is in zone %s, not %s", got, want), nil
+	}
+	if got := path.Base(inst.MachineType); got != ic.Hardware {
+		return inst, fmt.Sprintf("it is a %s, not a %s", got, ic.Hardware), nil
+	}
+	return inst, "", nil
+}
+
+// AdoptNode returns a NodeInfo for reaching the existing instance inst
+// instead of making it again. inst must be running.
+func (c *Client) AdoptNode(inst *compute.Instance) (*NodeInfo, error) {
+	if inst.Status != "RUNNING" {
+		return nil, fmt.Errorf("%s is %s, not RUNNING: start it with gocloud start %s", inst.Name, inst.Status, inst.Name)
+	}
+	return c.nodeInfo(inst)
+}
+
+// ReplaceNode deletes the existing instance inst so that MakeNode can make
+// it again from the configuration configName. It refuses to delete an
+// instance that gocloud didn't make. It also deletes the data disks that
+// making inst created and that outlive it, except for those that
+// configName reuses, writing what it deletes to w.
+func (c *Client) ReplaceNode(w io.Writer, inst *compute.Instance, configName string) error {
+	if !MadeByGocloud(inst) {
+		return fmt.Errorf("%s wasn't made by gocloud: delete it yourself to replace it", inst.Name)
+	}
+	ic, err := c.settings.Instance(configName)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for _, dc := range ic.Disks {
+		if !dc.Reuse {
+			continue
+		}
+		disk, err := dc.DiskName(inst.Name, configName)
+		if err != nil {
+			return err
+		}
+		keep[disk] = true
+	}
+
+	zone := path.Base(inst.Zone)
+	if err := c.Delete(zone, inst.Name); err != nil {
+		return err
+	}
+	old := instanceConfigName(inst)
+	if _, err := c.settings.Instance(old); err != nil {
+		fmt.Fprintf(w, "leaving the data disks of %s: its configuration %s is gone\n", inst.Name, old)
+		return nil
+	}
+	return c.deleteNewDataDisks(w, zone, old, inst.Name, keep)
+}
+
+// MadeByGocloud returns true if inst has the configuration name that
+// gocloud records in the metadata or labels of the nodes that it makes.
+func MadeByGocloud(inst *compute.Instance) bool {
+	if inst.Metadata != nil {
+		for _, it := range inst.Metadata.Items {
+			if it.Key == configNameKey {
+				return true
+			}
+		}
+	}
+	_, ok := inst.Labels[configLabel]
+	return ok
+}
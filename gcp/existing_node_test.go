@@ -0,0 +1,107 @@
+package gcp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestExistingNode(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	inst, mismatch, err := c.ExistingNode("small", "worker")
+	if err != nil {
+		t.Fatalf("ExistingNode: %v", err)
+	}
+	if inst != nil {
+		t.Fatalf("ExistingNode found %s before it was made", inst.Name)
+	}
+
+	made, err := c.MakeNode("small", "worker")
+	if err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	inst, mismatch, err = c.ExistingNode("small", "worker")
+	if err != nil {
+		t.Fatalf("ExistingNode: %v", err)
+	}
+	if inst == nil || mismatch != "" {
+		t.Fatalf("ExistingNode got %v, %q, want the matching worker", inst, mismatch)
+	}
+
+	if _, mismatch, _ := c.ExistingNode("western", "worker"); !strings.Contains(mismatch, "configuration small, not western") {
+		t.Errorf("ExistingNode with another configuration got mismatch %q", mismatch)
+	}
+
+	ni, err := c.AdoptNode(inst)
+	if err != nil {
+		t.Fatalf("AdoptNode: %v", err)
+	}
+	if ni.Token != made.Token || ni.Addr != made.Addr {
+		t.Errorf("AdoptNode got %+v, want the NodeInfo from MakeNode %+v", ni, made)
+	}
+
+	if err := c.Stop("", "worker"); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	inst, _, err = c.ExistingNode("small", "worker")
+	if err != nil {
+		t.Fatalf("ExistingNode: %v", err)
+	}
+	if _, err := c.AdoptNode(inst); err == nil {
+		t.Error("AdoptNode should fail for a stopped node")
+	}
+}
+
+func TestReplaceNode(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["server"] = config.InstanceConfig{
+		Extends: "small",
+		Disks: []config.DiskConfig{
+			{Name: "{{.Node}}-data", Size: 10},
+			{Name: "{{.Node}}-home", Size: 10},
+			{Name: "shared", Size: 10, Reuse: true},
+		},
+	}
+	// The new configuration reuses the home disk of the old one.
+	c.settings.InstanceTypes["homely"] = config.InstanceConfig{
+		Extends: "small",
+		Disks: []config.DiskConfig{
+			{Name: "{{.Node}}-home", Size: 10, Reuse: true},
+		},
+	}
+	if _, err := c.MakeNode("server", "web"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.ReplaceNode(&buf, fake.Instance("us-east1-b", "web"), "homely"); err != nil {
+		t.Fatalf("ReplaceNode: %v", err)
+	}
+	if fake.Instance("us-east1-b", "web") != nil {
+		t.Error("ReplaceNode didn't delete the node")
+	}
+	if fake.Disk("us-east1-b", "web-data") != nil {
+		t.Error("ReplaceNode didn't delete the node's data disk")
+	}
+	if fake.Disk("us-east1-b", "web-home") == nil || fake.Disk("us-east1-b", "shared") == nil {
+		t.Error("ReplaceNode deleted a reused disk")
+	}
+	if want := "deleting disk web-data\n"; buf.String() != want {
+		t.Errorf("ReplaceNode wrote %q, want %q", buf.String(), want)
+	}
+
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name:        "foreign",
+		MachineType: "https://www.googleapis.com/compute/v1/projects/testproject/zones/us-east1-b/machineTypes/e2-small",
+	})
+	if err := c.ReplaceNode(&buf, fake.Instance("us-east1-b", "foreign"), "small"); err == nil {
+		t.Error("ReplaceNode of a node that gocloud didn't make should fail")
+	}
+	if fake.Instance("us-east1-b", "foreign") == nil {
+		t.Error("ReplaceNode deleted a node that gocloud didn't make")
+	}
+}
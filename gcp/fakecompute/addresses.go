@@ -0,0 +1,205 @@
+package fakecompute
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Address returns the address called name in region or nil if there's
+// no such address.
+func (s *Server) Address(region, name string) *compute.Address {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addresses[region][name]
+}
+
+// hasRegion returns true if region holds one of the server's zones.
+func (s *Server) hasRegion(region string) bool {
+	for _, z := range s.zones {
+		if zoneRegion(z) == region {
+			return true
+		}
+	}
+	return false
+}
+
+func zoneRegion(zone string) string {
+	return zone[:strings.LastIndex(zone, "-")]
+}
+
+func (s *Server) regionLink(region string) string {
+	return LinkPrefix + s.Project + "/regions/" + region
+}
+
+// newRegionOperation records a completed regional operation of kind op
+// on target.
+func (s *Server) newRegionOperation(region, op, target string) *compute.Operation {
+	o := s.newOperation("", op, target)
+	o.Zone = ""
+	o.Region = s.regionLink(region)
+	o.SelfLink = o.Region + "/operations/" + o.Name
+	return o
+}
+
+func (s *Server) serveRegions(w http.ResponseWriter, r *http.Request, ps []string) {
+	if len(ps) < 2 || !s.hasRegion(ps[0]) {
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+		return
+	}
+	region, ps := ps[0], ps[1:]
+	switch ps[0] {
+	case "addresses":
+		s.serveAddresses(w, r, region, ps[1:])
+	case "operations":
+		if len(ps) < 2 || len(ps) > 3 || (len(ps) == 3 && ps[2] != "wait") {
+			writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+			return
+		}
+		op, ok := s.operations[ps[1]]
+		if !ok || path.Base(op.Region) != region {
+			writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/regions/%s/operations/%s' was not found", s.Project, region, ps[1])
+			return
+		}
+		if len(ps) == 3 {
+			s.wait(r, op)
+		}
+		writeJSON(w, op)
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+	}
+}
+
+func (s *Server) serveAddresses(w http.ResponseWriter, r *http.Request, region string, ps []string) {
+	if s.addresses[region] == nil {
+		s.addresses[region] = make(map[string]*compute.Address)
+	}
+	addresses := s.addresses[region]
+
+	switch {
+	case len(ps) == 0 && r.Method == http.MethodGet:
+		names := make([]string, 0, len(addresses))
+		for n := range addresses {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		al := &compute.AddressList{Kind: "compute#addressList"}
+		for _, n := range names {
+			al.Items = append(al.Items, addresses[n])
+		}
+		writeJSON(w, al)
+	case len(ps) == 0 && r.Method == http.MethodPost:
+		addr := new(compute.Address)
+		if err := json.NewDecoder(r.Body).Decode(addr); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", "can't decode address: %v", err)
+			return
+		}
+		if addr.Name == "" {
+			writeError(w, http.StatusBadRequest, "required", "Required field 'resource.name' not specified")
+			return
+		}
+		if _, ok := addresses[addr.Name]; ok {
+			writeError(w, http.StatusConflict, "alreadyExists", "The resource 'projects/%s/regions/%s/addresses/%s' already exists", s.Project, region, addr.Name)
+			return
+		}
+		addr.Id = s.nextid
+		addr.Kind = "compute#address"
+		addr.Region = s.regionLink(region)
+		addr.SelfLink = addr.Region + "/addresses/" + addr.Name
+		addr.Address = fmt.Sprintf("198.51.100.%d", s.nextid%250+2)
+		addr.AddressType = "EXTERNAL"
+		addr.Status = "RESERVED"
+		addr.CreationTimestamp = timestamp()
+		s.nextid++
+		addresses[addr.Name] = addr
+		writeJSON(w, s.newRegionOperation(region, "insert", addr.SelfLink))
+	case len(ps) == 1:
+		addr, ok := addresses[ps[0]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/regions/%s/addresses/%s' was not found", s.Project, region, ps[0])
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			addr.Status = "RESERVED"
+			if s.addressUser(addr.Address) != "" {
+				addr.Status = "IN_USE"
+			}
+			writeJSON(w, addr)
+		case http.MethodDelete:
+			if user := s.addressUser(addr.Address); user != "" {
+				writeError(w, http.StatusBadRequest, "resourceInUseByAnotherResource", "The address resource '%s' is already being used by '%s'", addr.SelfLink, user)
+				return
+			}
+			delete(addresses, addr.Name)
+			writeJSON(w, s.newRegionOperation(region, "delete", addr.SelfLink))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "badRequest", "method %s not supported", r.Method)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+	}
+}
+
+// serveAggregatedAddresses lists the addresses of every region, optionally
+// filtered by name.
+func (s *Server) serveAggregatedAddresses(w http.ResponseWriter, r *http.Request) {
+	field, value, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil || (field != "" && field != "name") {
+		writeError(w, http.StatusBadRequest, "invalid", "unsupported filter %q", r.URL.Query().Get("filter"))
+		return
+	}
+
+	regions := make([]string, 0, len(s.addresses))
+	for region := range s.addresses {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	al := &compute.AddressAggregatedList{
+		Kind:  "compute#addressAggregatedList",
+		Items: make(map[string]compute.AddressesScopedList),
+	}
+	for _, region := range regions {
+		names := make([]string, 0, len(s.addresses[region]))
+		for n := range s.addresses[region] {
+			if field == "" || n == value {
+				names = append(names, n)
+			}
+		}
+		sort.Strings(names)
+		addrs := make([]*compute.Address, 0, len(names))
+		for _, n := range names {
+			addr := s.addresses[region][n]
+			addr.Status = "RESERVED"
+			if s.addressUser(addr.Address) != "" {
+				addr.Status = "IN_USE"
+			}
+			addrs = append(addrs, addr)
+		}
+		al.Items["regions/"+region] = compute.AddressesScopedList{Addresses: addrs}
+	}
+	writeJSON(w, al)
+}
+
+// addressUser returns the self link of the instance using the external
+// address ip or "" if none is.
+func (s *Server) addressUser(ip string) string {
+	for _, insts := range s.instances {
+		for _, inst := range insts {
+			for _, ni := range inst.NetworkInterfaces {
+				for _, ac := range ni.AccessConfigs {
+					if ac.NatIP == ip {
+						return inst.SelfLink
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,247 @@
+package fakecompute
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// AddDisk adds disk to zone, filling in its output-only fields.
+func (s *Server) AddDisk(zone string, disk *compute.Disk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addDisk(zone, disk)
+}
+
+// Disk returns the disk called name in zone or nil if there's no such
+// disk.
+func (s *Server) Disk(zone, name string) *compute.Disk {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.disks[zone][name]
+}
+
+func (s *Server) addDisk(zone string, disk *compute.Disk) {
+	disk.Id = s.nextid
+	s.nextid++
+	disk.Kind = "compute#disk"
+	disk.Zone = s.zoneLink(zone)
+	disk.SelfLink = disk.Zone + "/disks/" + disk.Name
+	disk.Status = "READY"
+	if disk.Type == "" {
+		disk.Type = disk.Zone + "/diskTypes/pd-standard"
+	}
+	if disk.SizeGb == 0 {
+		disk.SizeGb = 10
+	}
+	if disk.CreationTimestamp == "" {
+		disk.CreationTimestamp = timestamp()
+	}
+	s.disks[zone][disk.Name] = disk
+}
+
+func (s *Server) serveDisks(w http.ResponseWriter, r *http.Request, zone string, ps []string) {
+	switch {
+	case len(ps) == 0 && r.Method == http.MethodGet:
+		names := make([]string, 0, len(s.disks[zone]))
+		for n := range s.disks[zone] {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		dl := &compute.DiskList{Kind: "compute#diskList"}
+		for _, n := range names {
+			dl.Items = append(dl.Items, s.disks[zone][n])
+		}
+		writeJSON(w, dl)
+	case len(ps) == 0 && r.Method == http.MethodPost:
+		disk := new(compute.Disk)
+		if err := json.NewDecoder(r.Body).Decode(disk); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", "can't decode disk: %v", err)
+			return
+		}
+		if disk.Name == "" {
+			writeError(w, http.StatusBadRequest, "required", "Required field 'resource.name' not specified")
+			return
+		}
+		if _, ok := s.disks[zone][disk.Name]; ok {
+			writeError(w, http.StatusConflict, "alreadyExists", "The resource 'projects/%s/zones/%s/disks/%s' already exists", s.Project, zone, disk.Name)
+			return
+		}
+		s.addDisk(zone, disk)
+		writeJSON(w, s.newOperation(zone, "insert", disk.SelfLink))
+	case len(ps) == 1:
+		disk, ok := s.disks[zone][ps[0]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/zones/%s/disks/%s' was not found", s.Project, zone, ps[0])
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, disk)
+		case http.MethodDelete:
+			if len(disk.Users) > 0 {
+				writeError(w, http.StatusBadRequest, "resourceInUseByAnotherResource", "The disk resource '%s' is already being used by '%s'", disk.SelfLink, disk.Users[0])
+				return
+			}
+			delete(s.disks[zone], disk.Name)
+			writeJSON(w, s.newOperation(zone, "delete", disk.SelfLink))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "badRequest", "method %s not supported", r.Method)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+	}
+}
+
+// checkAttachedDisks returns an error if the disks of the new instance
+// inst can't be made or attached in zone.
+func (s *Server) checkAttachedDisks(zone string, inst *compute.Instance) error {
+	for _, ad := range inst.Disks {
+		if ad.Type == "SCRATCH" {
+			continue
+		}
+		if ad.InitializeParams != nil {
+			name := ad.InitializeParams.DiskName
+			if name == "" {
+				name = inst.Name
+			}
+			if _, ok := s.disks[zone][name]; ok {
+				return fmt.Errorf("The resource 'projects/%s/zones/%s/disks/%s' already exists", s.Project, zone, name)
+			}
+			continue
+		}
+		if err := s.checkAttachable(zone, ad); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAttachable returns an error if the existing disk ad can't be
+// attached in zone.
+func (s *Server) checkAttachable(zone string, ad *compute.AttachedDisk) error {
+	disk, ok := s.disks[zone][path.Base(ad.Source)]
+	if !ok {
+		return fmt.Errorf("The resource '%s' was not found", ad.Source)
+	}
+	if len(disk.Users) > 0 && ad.Mode != "READ_ONLY" {
+		return fmt.Errorf("The disk resource '%s' is already being used by '%s'", disk.SelfLink, disk.Users[0])
+	}
+	return nil
+}
+
+// attachDisks makes the disks of the new instance inst and marks them as
+// used by it.
+func (s *Server) attachDisks(zone string, inst *compute.Instance) {
+	nssd := 0
+	for i, ad := range inst.Disks {
+		if ad.Type == "SCRATCH" {
+			// Local SSDs aren't disk resources.
+			ad.Kind = "compute#attachedDisk"
+			ad.Index = int64(i)
+			ad.Mode = "READ_WRITE"
+			ad.DeviceName = fmt.Sprintf("local-ssd-%d", nssd)
+			nssd++
+			continue
+		}
+		if ip := ad.InitializeParams; ip != nil {
+			disk := &compute.Disk{
+				Name:        ip.DiskName,
+				SizeGb:      ip.DiskSizeGb,
+				SourceImage: ip.SourceImage,
+				Type:        ip.DiskType,
+			}
+			if disk.Name == "" {
+				disk.Name = inst.Name
+			}
+			s.addDisk(zone, disk)
+			ad.Source = disk.SelfLink
+		}
+		s.attachDisk(zone, inst, ad, i)
+	}
+}
+
+// attachDisk marks the disk ad as used by inst, filling in the output
+// fields of ad.
+func (s *Server) attachDisk(zone string, inst *compute.Instance, ad *compute.AttachedDisk, index int) {
+	disk := s.disks[zone][path.Base(ad.Source)]
+	disk.Users = append(disk.Users, inst.SelfLink)
+	ad.Kind = "compute#attachedDisk"
+	ad.Index = int64(index)
+	ad.DiskSizeGb = disk.SizeGb
+	if ad.Type == "" {
+		ad.Type = "PERSISTENT"
+	}
+	if ad.Mode == "" {
+		ad.Mode = "READ_WRITE"
+	}
+	if ad.DeviceName == "" {
+		ad.DeviceName = fmt.Sprintf("persistent-disk-%d", index)
+	}
+}
+
+// detachDisk removes inst from the users of the disk ad and deletes the
+// disk if it's set to be deleted with the instance and deleting is
+// true.
+func (s *Server) detachDisk(zone string, inst *compute.Instance, ad *compute.AttachedDisk, deleting bool) {
+	disk, ok := s.disks[zone][path.Base(ad.Source)]
+	if !ok {
+		return
+	}
+	users := disk.Users[:0]
+	for _, u := range disk.Users {
+		if u != inst.SelfLink {
+			users = append(users, u)
+		}
+	}
+	disk.Users = users
+	if deleting && ad.AutoDelete && len(disk.Users) == 0 {
+		delete(s.disks[zone], disk.Name)
+	}
+}
+
+// serveAttachDisk attaches the disk in the request body to inst.
+func (s *Server) serveAttachDisk(w http.ResponseWriter, r *http.Request, zone string, inst *compute.Instance) {
+	ad := new(compute.AttachedDisk)
+	if err := json.NewDecoder(r.Body).Decode(ad); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", "can't decode attached disk: %v", err)
+		return
+	}
+	if err := s.checkAttachable(zone, ad); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", "%v", err)
+		return
+	}
+	for _, other := range inst.Disks {
+		if other.DeviceName == ad.DeviceName || other.Source == ad.Source {
+			writeError(w, http.StatusBadRequest, "invalid", "The disk is already attached to '%s'", inst.SelfLink)
+			return
+		}
+	}
+	s.attachDisk(zone, inst, ad, len(inst.Disks))
+	inst.Disks = append(inst.Disks, ad)
+	writeJSON(w, s.newOperation(zone, "attachDisk", inst.SelfLink))
+}
+
+// serveDetachDisk detaches the disk named by the deviceName parameter
+// from inst.
+func (s *Server) serveDetachDisk(w http.ResponseWriter, r *http.Request, zone string, inst *compute.Instance) {
+	device := r.URL.Query().Get("deviceName")
+	for i, ad := range inst.Disks {
+		if ad.DeviceName != device {
+			continue
+		}
+		if ad.Boot {
+			writeError(w, http.StatusBadRequest, "invalid", "The boot disk can't be detached")
+			return
+		}
+		s.detachDisk(zone, inst, ad, false)
+		inst.Disks = append(inst.Disks[:i], inst.Disks[i+1:]...)
+		writeJSON(w, s.newOperation(zone, "detachDisk", inst.SelfLink))
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid", "No attached disk found with device name '%s'", device)
+}
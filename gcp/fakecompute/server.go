@@ -0,0 +1,676 @@
+// Package fakecompute provides an in-memory emulation of the parts of
+// the Compute Engine API that gocloud uses. Point a compute.Service at
+// it with option.WithEndpoint(s.Endpoint()) to test without a live GCP
+// project. It also serves the IAM service account requests that gocloud
+// makes from the root of the same host.
+package fakecompute
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// LinkPrefix is the prefix of the self links of the resources made by
+// the fake. It matches the real API so that code that parses links
+// sees the usual shape.
+const LinkPrefix = "https://www.googleapis.com/compute/v1/projects/"
+
+// Server is a fake Compute Engine API server. It serves a single
+// project. Mutating requests take effect immediately and return
+// operations that are already DONE.
+type Server struct {
+	// URL is the base URL of the running server.
+	URL string
+
+	// Project is the project served by the fake.
+	Project string
+
+	// NatIP, when set, is the external address given to every instance.
+	// Otherwise, each instance gets its own address from 192.0.2.0/24.
+	NatIP string
+
+	// Stall, when set, leaves new operations RUNNING. Their changes
+	// still take effect immediately but waiting for them never ends.
+	Stall bool
+
+	// Warnings are added to every new operation.
+	Warnings []*compute.OperationWarnings
+
+	// Staging is the number of times that getting a newly inserted
+	// instance reports it STAGING before it is RUNNING.
+	Staging int
+
+	srv *httptest.Server
+
+	mu         sync.Mutex
+	zones      []string
+	instances  map[string]map[string]*compute.Instance
+	disks      map[string]map[string]*compute.Disk
+	addresses  map[string]map[string]*compute.Address
+	images     map[string][]*compute.Image
+	operations map[string]*compute.Operation
+	requests   []string
+	nextid     uint64
+
+	// serviceaccounts maps the email of each service account to
+	// whether the caller can act as it.
+	serviceaccounts map[string]bool
+
+	// insertfailure, when set, is the error of the next instance insert.
+	insertfailure *compute.OperationErrorErrors
+
+	// serialoutput is the serial console output of instances, indexed
+	// by zone/name.
+	serialoutput map[string]string
+
+	// staging counts down the gets of each new instance, indexed by
+	// zone/name, that still report it STAGING.
+	staging map[string]int
+}
+
+// NewServer starts a fake server for project with the given zones.
+// Call Close when done.
+func NewServer(project string, zones ...string) *Server {
+	s := &Server{
+		Project:    project,
+		zones:      zones,
+		instances:  make(map[string]map[string]*compute.Instance),
+		disks:      make(map[string]map[string]*compute.Disk),
+		addresses:  make(map[string]map[string]*compute.Address),
+		images:     make(map[string][]*compute.Image),
+		operations: make(map[string]*compute.Operation),
+		nextid:     1,
+	}
+	for _, z := range zones {
+		s.instances[z] = make(map[string]*compute.Instance)
+		s.disks[z] = make(map[string]*compute.Disk)
+	}
+	s.serviceaccounts = map[string]bool{s.DefaultServiceAccount(): true}
+	s.serialoutput = make(map[string]string)
+	s.staging = make(map[string]int)
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Endpoint returns the value for option.WithEndpoint that directs a
+// compute.Service to this server.
+func (s *Server) Endpoint() string {
+	return s.URL + "/compute/v1/"
+}
+
+// AddImages adds images to the image list of project.
+func (s *Server) AddImages(project string, images ...*compute.Image) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, im := range images {
+		if im.SelfLink == "" {
+			im.SelfLink = LinkPrefix + project + "/global/images/" + im.Name
+		}
+		s.images[project] = append(s.images[project], im)
+	}
+}
+
+// AddInstance adds inst to zone as if it had been made with an insert
+// request.
+func (s *Server) AddInstance(zone string, inst *compute.Instance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addInstance(zone, inst)
+}
+
+// Instance returns the instance called name in zone or nil if there is
+// no such instance.
+func (s *Server) Instance(zone, name string) *compute.Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.instances[zone][name]
+}
+
+// Instances returns the instances in zone sorted by name.
+func (s *Server) Instances(zone string) []*compute.Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedInstances(zone)
+}
+
+// FailInsert makes the next instance insert fail with an operation
+// error with code and message, as when a quota is exceeded. The insert
+// request itself succeeds but the instance isn't made.
+func (s *Server) FailInsert(code, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insertfailure = &compute.OperationErrorErrors{Code: code, Message: message}
+}
+
+// SetSerialPortOutput sets the serial console output of the instance
+// called name in zone.
+func (s *Server) SetSerialPortOutput(zone, name, contents string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serialoutput[zone+"/"+name] = contents
+}
+
+// Requests returns the method and path of each request served so far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) sortedInstances(zone string) []*compute.Instance {
+	insts := make([]*compute.Instance, 0, len(s.instances[zone]))
+	for _, inst := range s.instances[zone] {
+		insts = append(insts, inst)
+	}
+	sort.Slice(insts, func(i, j int) bool { return insts[i].Name < insts[j].Name })
+	return insts
+}
+
+func (s *Server) hasZone(zone string) bool {
+	_, ok := s.instances[zone]
+	return ok
+}
+
+func (s *Server) zoneLink(zone string) string {
+	return LinkPrefix + s.Project + "/zones/" + zone
+}
+
+// addInstance fills in the output-only fields of inst and stores it.
+func (s *Server) addInstance(zone string, inst *compute.Instance) {
+	inst.Id = s.nextid
+	s.nextid++
+	inst.Kind = "compute#instance"
+	inst.Zone = s.zoneLink(zone)
+	inst.SelfLink = inst.Zone + "/instances/" + inst.Name
+	if inst.Status == "" {
+		inst.Status = "RUNNING"
+	}
+	if inst.CreationTimestamp == "" {
+		inst.CreationTimestamp = timestamp()
+	}
+	if inst.LastStartTimestamp == "" && inst.Status == "RUNNING" {
+		inst.LastStartTimestamp = inst.CreationTimestamp
+	}
+	for _, ni := range inst.NetworkInterfaces {
+		if ni.NetworkIP == "" {
+			ni.NetworkIP = fmt.Sprintf("10.128.0.%d", inst.Id%250+2)
+		}
+		for _, ac := range ni.AccessConfigs {
+			if ac.Type == "ONE_TO_ONE_NAT" && ac.NatIP == "" {
+				ac.NatIP = s.natIP()
+			}
+		}
+	}
+	s.instances[zone][inst.Name] = inst
+}
+
+func (s *Server) natIP() string {
+	if s.NatIP != "" {
+		return s.NatIP
+	}
+	return fmt.Sprintf("192.0.2.%d", s.nextid%250+2)
+}
+
+// newOperation records a completed operation of kind op on target. With
+// Stall set, the operation is still RUNNING.
+func (s *Server) newOperation(zone, op, target string) *compute.Operation {
+	now := timestamp()
+	o := &compute.Operation{
+		Kind:          "compute#operation",
+		Id:            s.nextid,
+		Name:          fmt.Sprintf("operation-%d", s.nextid),
+		Zone:          s.zoneLink(zone),
+		OperationType: op,
+		TargetLink:    target,
+		Status:        "DONE",
+		Progress:      100,
+		Warnings:      s.Warnings,
+		InsertTime:    now,
+		StartTime:     now,
+		EndTime:       now,
+	}
+	s.nextid++
+	o.SelfLink = o.Zone + "/operations/" + o.Name
+	if s.Stall {
+		o.Status = "RUNNING"
+		o.Progress = 0
+		o.EndTime = ""
+	}
+	s.operations[o.Name] = o
+	return o
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	if strings.HasPrefix(r.URL.Path, iamPrefix) {
+		s.serveServiceAccounts(w, r)
+		return
+	}
+	prefix := "/compute/v1/projects/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+		return
+	}
+	ps := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	project, ps := ps[0], ps[1:]
+
+	switch {
+	case len(ps) >= 2 && ps[0] == "global" && ps[1] == "images":
+		s.serveImages(w, r, project, ps[2:])
+	case project != s.Project:
+		writeError(w, http.StatusForbidden, "forbidden", "project %q is not served by the fake", project)
+	case len(ps) == 0:
+		s.serveProject(w, r)
+	case len(ps) >= 1 && ps[0] == "zones":
+		s.serveZones(w, r, ps[1:])
+	case len(ps) >= 1 && ps[0] == "regions":
+		s.serveRegions(w, r, ps[1:])
+	case len(ps) == 2 && ps[0] == "aggregated" && ps[1] == "instances":
+		s.serveAggregatedInstances(w, r)
+	case len(ps) == 2 && ps[0] == "aggregated" && ps[1] == "addresses":
+		s.serveAggregatedAddresses(w, r)
+	case len(ps) == 2 && ps[0] == "aggregated" && ps[1] == "operations":
+		s.serveAggregatedOperations(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+	}
+}
+
+func (s *Server) serveZones(w http.ResponseWriter, r *http.Request, ps []string) {
+	if len(ps) == 0 {
+		zl := &compute.ZoneList{Kind: "compute#zoneList"}
+		for _, z := range s.zones {
+			zl.Items = append(zl.Items, s.zone(z))
+		}
+		writeJSON(w, zl)
+		return
+	}
+
+	zone, ps := ps[0], ps[1:]
+	if !s.hasZone(zone) {
+		writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/zones/%s' was not found", s.Project, zone)
+		return
+	}
+	switch {
+	case len(ps) == 0:
+		writeJSON(w, s.zone(zone))
+	case ps[0] == "instances":
+		s.serveInstances(w, r, zone, ps[1:])
+	case ps[0] == "disks":
+		s.serveDisks(w, r, zone, ps[1:])
+	case ps[0] == "operations":
+		s.serveOperations(w, r, zone, ps[1:])
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+	}
+}
+
+func (s *Server) zone(zone string) *compute.Zone {
+	return &compute.Zone{
+		Kind:     "compute#zone",
+		Name:     zone,
+		Status:   "UP",
+		Region:   LinkPrefix + s.Project + "/regions/" + zone[:strings.LastIndex(zone, "-")],
+		SelfLink: s.zoneLink(zone),
+	}
+}
+
+func (s *Server) serveInstances(w http.ResponseWriter, r *http.Request, zone string, ps []string) {
+	switch {
+	case len(ps) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, &compute.InstanceList{
+			Kind:  "compute#instanceList",
+			Items: s.sortedInstances(zone),
+		})
+	case len(ps) == 0 && r.Method == http.MethodPost:
+		inst := new(compute.Instance)
+		if err := json.NewDecoder(r.Body).Decode(inst); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", "can't decode instance: %v", err)
+			return
+		}
+		if inst.Name == "" {
+			writeError(w, http.StatusBadRequest, "required", "Required field 'resource.name' not specified")
+			return
+		}
+		if _, ok := s.instances[zone][inst.Name]; ok {
+			writeError(w, http.StatusConflict, "alreadyExists", "The resource 'projects/%s/zones/%s/instances/%s' already exists", s.Project, zone, inst.Name)
+			return
+		}
+		if err := s.checkAttachedDisks(zone, inst); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", "%v", err)
+			return
+		}
+		if oe := s.insertfailure; oe != nil {
+			s.insertfailure = nil
+			op := s.newOperation(zone, "insert", s.zoneLink(zone)+"/instances/"+inst.Name)
+			op.Status = "DONE"
+			op.Error = &compute.OperationError{Errors: []*compute.OperationErrorErrors{oe}}
+			op.HttpErrorStatusCode = http.StatusForbidden
+			op.HttpErrorMessage = "FORBIDDEN"
+			writeJSON(w, op)
+			return
+		}
+		s.addInstance(zone, inst)
+		s.attachDisks(zone, inst)
+		if s.Staging > 0 {
+			s.staging[zone+"/"+inst.Name] = s.Staging
+		}
+		writeJSON(w, s.newOperation(zone, "insert", inst.SelfLink))
+	case len(ps) == 1:
+		inst, ok := s.instances[zone][ps[0]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/zones/%s/instances/%s' was not found", s.Project, zone, ps[0])
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if key := zone + "/" + inst.Name; s.staging[key] > 0 {
+				s.staging[key]--
+				staging := *inst
+				staging.Status = "STAGING"
+				writeJSON(w, &staging)
+				return
+			}
+			writeJSON(w, inst)
+		case http.MethodDelete:
+			for _, ad := range inst.Disks {
+				s.detachDisk(zone, inst, ad, true)
+			}
+			delete(s.instances[zone], inst.Name)
+			writeJSON(w, s.newOperation(zone, "delete", inst.SelfLink))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "badRequest", "method %s not supported", r.Method)
+		}
+	case len(ps) == 2 && r.Method == http.MethodGet && ps[1] == "serialPort":
+		inst, ok := s.instances[zone][ps[0]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/zones/%s/instances/%s' was not found", s.Project, zone, ps[0])
+			return
+		}
+		contents := s.serialoutput[zone+"/"+inst.Name]
+		writeJSON(w, &compute.SerialPortOutput{
+			Kind:     "compute#serialPortOutput",
+			Contents: contents,
+			Next:     int64(len(contents)),
+			SelfLink: inst.SelfLink + "/serialPort",
+		})
+	case len(ps) == 2 && r.Method == http.MethodPost:
+		inst, ok := s.instances[zone][ps[0]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/zones/%s/instances/%s' was not found", s.Project, zone, ps[0])
+			return
+		}
+		s.serveInstanceAction(w, r, zone, inst, ps[1])
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+	}
+}
+
+// serveInstanceAction changes the state of inst. Stopping or suspending
+// an instance releases its ephemeral addresses and starting or resuming
+// it assigns new ones.
+func (s *Server) serveInstanceAction(w http.ResponseWriter, r *http.Request, zone string, inst *compute.Instance, action string) {
+	switch action {
+	case "stop":
+		inst.Status = "TERMINATED"
+		s.setNatIPs(inst, "")
+	case "suspend":
+		if inst.Status != "RUNNING" {
+			writeError(w, http.StatusBadRequest, "resourceNotReady", "The instance '%s' is not running", inst.Name)
+			return
+		}
+		inst.Status = "SUSPENDED"
+		s.setNatIPs(inst, "")
+	case "start":
+		if inst.Status == "TERMINATED" {
+			s.setNatIPs(inst, s.natIP())
+			inst.LastStartTimestamp = timestamp()
+		}
+		inst.Status = "RUNNING"
+	case "resume":
+		if inst.Status != "SUSPENDED" {
+			writeError(w, http.StatusBadRequest, "resourceNotReady", "The instance '%s' is not suspended", inst.Name)
+			return
+		}
+		inst.Status = "RUNNING"
+		s.setNatIPs(inst, s.natIP())
+		inst.LastStartTimestamp = timestamp()
+	case "reset":
+		if inst.Status != "RUNNING" {
+			writeError(w, http.StatusBadRequest, "resourceNotReady", "The instance '%s' is not running", inst.Name)
+			return
+		}
+	case "attachDisk":
+		s.serveAttachDisk(w, r, zone, inst)
+		return
+	case "detachDisk":
+		s.serveDetachDisk(w, r, zone, inst)
+		return
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+		return
+	}
+	writeJSON(w, s.newOperation(zone, action, inst.SelfLink))
+}
+
+// setNatIPs sets the address of each of inst's external access configs.
+func (s *Server) setNatIPs(inst *compute.Instance, ip string) {
+	for _, ni := range inst.NetworkInterfaces {
+		for _, ac := range ni.AccessConfigs {
+			if ac.Type == "ONE_TO_ONE_NAT" {
+				ac.NatIP = ip
+			}
+		}
+	}
+}
+
+// serveAggregatedInstances lists the instances of every zone. Like the
+// real API, zones without instances carry a warning instead.
+func (s *Server) serveAggregatedInstances(w http.ResponseWriter, r *http.Request) {
+	field, value, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil || (field != "" && field != "name") {
+		writeError(w, http.StatusBadRequest, "invalid", "unsupported filter %q", r.URL.Query().Get("filter"))
+		return
+	}
+	match := func(inst *compute.Instance) bool { return field == "" || inst.Name == value }
+
+	al := &compute.InstanceAggregatedList{
+		Kind:  "compute#instanceAggregatedList",
+		Items: make(map[string]compute.InstancesScopedList),
+	}
+	for _, z := range s.zones {
+		insts := make([]*compute.Instance, 0)
+		for _, inst := range s.sortedInstances(z) {
+			if match(inst) {
+				insts = append(insts, inst)
+			}
+		}
+		if len(insts) == 0 {
+			al.Items["zones/"+z] = compute.InstancesScopedList{
+				Warning: &compute.InstancesScopedListWarning{
+					Code:    "NO_RESULTS_ON_PAGE",
+					Message: "There are no results for scope 'zones/" + z + "' on this page.",
+				},
+			}
+			continue
+		}
+		al.Items["zones/"+z] = compute.InstancesScopedList{Instances: insts}
+	}
+	writeJSON(w, al)
+}
+
+// serveAggregatedOperations lists the operations of every zone and region,
+// optionally filtered by operationType.
+func (s *Server) serveAggregatedOperations(w http.ResponseWriter, r *http.Request) {
+	field, value, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil || (field != "" && field != "operationType") {
+		writeError(w, http.StatusBadRequest, "invalid", "unsupported filter %q", r.URL.Query().Get("filter"))
+		return
+	}
+
+	names := make([]string, 0, len(s.operations))
+	for n := range s.operations {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	al := &compute.OperationAggregatedList{
+		Kind:  "compute#operationAggregatedList",
+		Items: make(map[string]compute.OperationsScopedList),
+	}
+	for _, n := range names {
+		op := s.operations[n]
+		if field != "" && op.OperationType != value {
+			continue
+		}
+		scope := "zones/" + path.Base(op.Zone)
+		if op.Zone == "" {
+			scope = "regions/" + path.Base(op.Region)
+		}
+		sl := al.Items[scope]
+		sl.Operations = append(sl.Operations, op)
+		al.Items[scope] = sl
+	}
+	writeJSON(w, al)
+}
+
+// Preempt preempts the instance name in zone like Compute Engine
+// reclaiming a spot or preemptible instance with the stop termination
+// action.
+func (s *Server) Preempt(zone, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.instances[zone][name]
+	inst.Status = "TERMINATED"
+	s.setNatIPs(inst, "")
+	s.newOperation(zone, "compute.instances.preempted", inst.SelfLink)
+}
+
+func (s *Server) serveOperations(w http.ResponseWriter, r *http.Request, zone string, ps []string) {
+	if len(ps) == 0 || len(ps) > 2 || (len(ps) == 2 && ps[1] != "wait") {
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+		return
+	}
+	op, ok := s.operations[ps[0]]
+	if !ok || path.Base(op.Zone) != zone {
+		writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/zones/%s/operations/%s' was not found", s.Project, zone, ps[0])
+		return
+	}
+	if len(ps) == 2 {
+		s.wait(r, op)
+	}
+	writeJSON(w, op)
+}
+
+// wait blocks a wait request for op, like the real API, while op is
+// running. It returns after a second or when the client gives up.
+func (s *Server) wait(r *http.Request, op *compute.Operation) {
+	if op.Status == "DONE" {
+		return
+	}
+	s.mu.Unlock()
+	defer s.mu.Lock()
+	select {
+	case <-r.Context().Done():
+	case <-time.After(time.Second):
+	}
+}
+
+func (s *Server) serveImages(w http.ResponseWriter, r *http.Request, project string, ps []string) {
+	switch len(ps) {
+	case 0:
+		writeJSON(w, &compute.ImageList{
+			Kind:  "compute#imageList",
+			Items: s.images[project],
+		})
+	case 1:
+		for _, im := range s.images[project] {
+			if im.Name == ps[0] {
+				writeJSON(w, im)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/global/images/%s' was not found", project, ps[0])
+	case 2:
+		if ps[0] != "family" {
+			writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+			return
+		}
+		// The newest image is the last one added.
+		images := s.images[project]
+		for i := len(images) - 1; i >= 0; i-- {
+			if im := images[i]; im.Family == ps[1] && im.Deprecated == nil {
+				writeJSON(w, im)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "notFound", "The resource 'projects/%s/global/images/family/%s' was not found", project, ps[1])
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+	}
+}
+
+// parseFilter supports the subset of the list filter syntax that
+// gocloud uses: an empty filter or a single name = "value" comparison.
+func parseFilter(filter string) (field, value string, err error) {
+	if filter == "" {
+		return "", "", nil
+	}
+	f := strings.SplitN(filter, "=", 2)
+	if len(f) != 2 {
+		return "", "", fmt.Errorf("unsupported filter %q", filter)
+	}
+	return strings.TrimSpace(f[0]), strings.Trim(strings.TrimSpace(f[1]), `"`), nil
+}
+
+// timestamp returns the current time formatted like the API's
+// timestamps, with milliseconds.
+func timestamp() string {
+	return time.Now().Format("2006-01-02T15:04:05.000-07:00")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeError writes an error body in the format that googleapi.CheckResponse
+// decodes.
+func writeError(w http.ResponseWriter, code int, reason, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": msg,
+			"errors": []map[string]string{
+				{
+					"domain":  "global",
+					"reason":  reason,
+					"message": msg,
+				},
+			},
+		},
+	})
+}
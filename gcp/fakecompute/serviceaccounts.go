@@ -0,0 +1,77 @@
+package fakecompute
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+	iam "google.golang.org/api/iam/v1"
+)
+
+// iamPrefix is the prefix of the IAM API paths served by the fake.
+const iamPrefix = "/v1/projects/-/serviceAccounts/"
+
+// DefaultServiceAccount returns the email address of the project's
+// Compute Engine default service account. It exists and the caller can
+// act as it.
+func (s *Server) DefaultServiceAccount() string {
+	return "123456789-compute@developer.gserviceaccount.com"
+}
+
+// AddServiceAccount adds the service account email. The caller can act
+// as it if actAs is true.
+func (s *Server) AddServiceAccount(email string, actAs bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serviceaccounts[email] = actAs
+}
+
+// serveProject serves the project resource.
+func (s *Server) serveProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "badRequest", "method %s not supported", r.Method)
+		return
+	}
+	writeJSON(w, &compute.Project{
+		Kind:                  "compute#project",
+		Name:                  s.Project,
+		DefaultServiceAccount: s.DefaultServiceAccount(),
+		SelfLink:              LinkPrefix + s.Project,
+	})
+}
+
+// serveServiceAccounts serves the IAM service account get and
+// testIamPermissions requests.
+func (s *Server) serveServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	email, method, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, iamPrefix), ":")
+	actAs, ok := s.serviceaccounts[email]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Service account projects/-/serviceAccounts/%s not found", email)
+		return
+	}
+
+	switch {
+	case method == "" && r.Method == http.MethodGet:
+		writeJSON(w, &iam.ServiceAccount{
+			Email:     email,
+			Name:      "projects/" + s.Project + "/serviceAccounts/" + email,
+			ProjectId: s.Project,
+		})
+	case method == "testIamPermissions" && r.Method == http.MethodPost:
+		req := new(iam.TestIamPermissionsRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", "can't decode request: %v", err)
+			return
+		}
+		res := &iam.TestIamPermissionsResponse{}
+		for _, p := range req.Permissions {
+			if actAs && p == "iam.serviceAccounts.actAs" {
+				res.Permissions = append(res.Permissions, p)
+			}
+		}
+		writeJSON(w, res)
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path %q", r.URL.Path)
+	}
+}
@@ -0,0 +1,70 @@
+package gcp
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// FindZone returns the zone containing the instance called name. It is
+// an error if there's no such instance or if instances called name exist
+// in more than one zone.
+func (c *Client) FindZone(name string) (string, error) {
+	insts, err := c.findInstances(name)
+	if err != nil {
+		return "", err
+	}
+	zones := make([]string, 0, len(insts))
+	for _, inst := range insts {
+		zones = append(zones, path.Base(inst.Zone))
+	}
+
+	switch len(zones) {
+	case 0:
+		return "", &noInstanceError{name: name, project: c.ProjectId}
+	case 1:
+		return zones[0], nil
+	}
+	sort.Strings(zones)
+	return "", fmt.Errorf("instance %s is ambiguous: it exists in zones %s. Specify one with --zone", name, strings.Join(zones, ", "))
+}
+
+// noInstanceError is the error of FindZone when there's no instance
+// called name.
+type noInstanceError struct {
+	name, project string
+}
+
+func (e *noInstanceError) Error() string {
+	return fmt.Sprintf("no instance %s in any zone of project %s", e.name, e.project)
+}
+
+// resolveZone returns zone if it's set and otherwise the zone holding the
+// instance called name.
+func (c *Client) resolveZone(zone, name string) (string, error) {
+	if zone != "" {
+		return zone, nil
+	}
+	return c.FindZone(name)
+}
+
+// findInstances returns the instances called name in every zone.
+func (c *Client) findInstances(name string) ([]*compute.Instance, error) {
+	insts := make([]*compute.Instance, 0, 1)
+	if err := c.service.Instances.AggregatedList(c.ProjectId).Filter(fmt.Sprintf("name = %q", name)).Pages(c.ctx, func(res *compute.InstanceAggregatedList) error {
+		for _, sl := range res.Items {
+			for _, inst := range sl.Instances {
+				if inst.Name == name {
+					insts = append(insts, inst)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("can't search for instance %s: %v", name, err)
+	}
+	return insts, nil
+}
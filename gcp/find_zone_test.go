@@ -0,0 +1,32 @@
+package gcp
+
+import (
+	"strings"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestFindZone(t *testing.T) {
+	c, fake := newTestClient(t)
+	fake.AddInstance("us-west1-a", &compute.Instance{Name: "western"})
+	fake.AddInstance("us-east1-b", &compute.Instance{Name: "twin"})
+	fake.AddInstance("us-west1-a", &compute.Instance{Name: "twin"})
+
+	zone, err := c.FindZone("western")
+	if err != nil {
+		t.Fatalf("FindZone: %v", err)
+	}
+	if got, want := zone, "us-west1-a"; got != want {
+		t.Errorf("FindZone got %q, want %q", got, want)
+	}
+
+	if _, err := c.FindZone("missing"); err == nil || !strings.Contains(err.Error(), "no instance missing") {
+		t.Errorf("FindZone of a missing instance got error %v", err)
+	}
+
+	_, err = c.FindZone("twin")
+	if err == nil || !strings.Contains(err.Error(), "us-east1-b, us-west1-a") {
+		t.Errorf("FindZone of an ambiguous instance got error %v", err)
+	}
+}
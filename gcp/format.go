@@ -0,0 +1,84 @@
+package gcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Format selects how the listing and describing commands print their
+// results.
+type Format struct {
+	kind string
+	tmpl *template.Template
+}
+
+// TableFormat is the default, human-readable, Format.
+var TableFormat = &Format{kind: "table"}
+
+// ParseFormat parses the value of the --format option. It is one of
+// table, json, yaml or otherwise a Go template (e.g. '{{.Name}}
+// {{.Addr}}') executed for each result.
+func ParseFormat(s string) (*Format, error) {
+	switch s {
+	case "", "table":
+		return TableFormat, nil
+	case "json", "yaml":
+		return &Format{kind: s}, nil
+	}
+
+	tmpl, err := template.New("format").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad format template %q: %v", s, err)
+	}
+	return &Format{kind: "template", tmpl: tmpl}, nil
+}
+
+// write prints v to w in format f. The table function prints the
+// table format. Templates are executed once for each element when v is
+// a slice.
+func (f *Format) write(w io.Writer, v interface{}, table func(io.Writer) error) error {
+	switch f.kind {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("can't make yaml: %v", err)
+		}
+		_, err = w.Write(b)
+		return err
+	case "template":
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			return f.execute(w, v)
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := f.execute(w, rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return table(w)
+}
+
+// execute runs the template on v, ending the output with a newline.
+func (f *Format) execute(w io.Writer, v interface{}) error {
+	var sb strings.Builder
+	if err := f.tmpl.Execute(&sb, v); err != nil {
+		return fmt.Errorf("can't execute format template: %v", err)
+	}
+	if !strings.HasSuffix(sb.String(), "\n") {
+		sb.WriteString("\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,162 @@
+package gcp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormat(t *testing.T) {
+	nodes := []*Node{
+		{
+			Name:        "alpha",
+			Zone:        "us-east1-b",
+			Status:      "RUNNING",
+			MachineType: "e2-small",
+			Created:     time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC),
+			ConfigName:  "small",
+			Addr:        "192.0.2.3",
+		},
+		{
+			Name:      "beta",
+			Status:    "TERMINATED",
+			Preempted: true,
+		},
+	}
+
+	for _, tv := range []struct {
+		format string
+		want   string
+	}{
+		{"", "table\n"},
+		{"table", "table\n"},
+		{"{{.Name}}={{.Status}}", "alpha=RUNNING\nbeta=TERMINATED\n"},
+		{"json", `[
+  {
+    "name": "alpha",
+    "zone": "us-east1-b",
+    "status": "RUNNING",
+    "machineType": "e2-small",
+    "created": "2023-05-06T07:08:09Z",
+    "config": "small",
+    "addr": "192.0.2.3",
+    "preempted": false
+  },
+  {
+    "name": "beta",
+    "zone": "",
+    "status": "TERMINATED",
+    "machineType": "",
+    "created": "0001-01-01T00:00:00Z",
+    "config": "",
+    "addr": "",
+    "preempted": true
+  }
+]
+`},
+		{"yaml", `- name: alpha
+  zone: us-east1-b
+  status: RUNNING
+  machineType: e2-small
+  created: 2023-05-06T07:08:09Z
+  config: small
+  addr: 192.0.2.3
+  preempted: false
+- name: beta
+  zone: ""
+  status: TERMINATED
+  machineType: ""
+  created: 0001-01-01T00:00:00Z
+  config: ""
+  addr: ""
+  preempted: true
+`},
+	} {
+		f, err := ParseFormat(tv.format)
+		if err != nil {
+			t.Fatalf("ParseFormat(%q): %v", tv.format, err)
+		}
+
+		var buffy bytes.Buffer
+		if err := f.write(&buffy, nodes, func(w io.Writer) error {
+			_, err := io.WriteString(w, "table\n")
+			return err
+		}); err != nil {
+			t.Fatalf("format %q: write: %v", tv.format, err)
+		}
+		if diff := cmp.Diff(tv.want, buffy.String()); diff != "" {
+			t.Errorf("format %q mismatch (-want +got):\n%s", tv.format, diff)
+		}
+	}
+
+	if _, err := ParseFormat("{{.Name"); err == nil {
+		t.Error("ParseFormat should reject a bad template")
+	}
+}
+
+func TestFormatDescription(t *testing.T) {
+	nd := &NodeDescription{
+		Node: Node{
+			Name:        "alpha",
+			Zone:        "us-east1-b",
+			Status:      "RUNNING",
+			MachineType: "e2-small",
+			Created:     time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC),
+			ConfigName:  "small",
+			Labels:      map[string]string{"team": "infra"},
+		},
+		Id:       "42",
+		Disks:    []DiskDescription{{Name: "alpha", SizeGb: 10, Boot: true, AutoDelete: true}},
+		Tags:     []string{"ssh"},
+		Metadata: map[string]string{"gocloud-config": "small"},
+	}
+
+	for _, tv := range []struct {
+		format string
+		want   string
+	}{
+		{"yaml", `name: alpha
+zone: us-east1-b
+status: RUNNING
+machineType: e2-small
+created: 2023-05-06T07:08:09Z
+config: small
+addr: ""
+preempted: false
+labels:
+  team: infra
+id: "42"
+description: ""
+internalAddr: ""
+disks:
+- name: alpha
+  sizeGb: 10
+  type: ""
+  mode: ""
+  boot: true
+  autoDelete: true
+serviceAccounts: []
+tags:
+- ssh
+metadata:
+  gocloud-config: small
+`},
+		{"{{.Name}} {{.Labels.team}}", "alpha infra\n"},
+	} {
+		f, err := ParseFormat(tv.format)
+		if err != nil {
+			t.Fatalf("ParseFormat(%q): %v", tv.format, err)
+		}
+
+		var buffy bytes.Buffer
+		if err := f.write(&buffy, nd, func(w io.Writer) error { return nil }); err != nil {
+			t.Fatalf("format %q: write: %v", tv.format, err)
+		}
+		if diff := cmp.Diff(tv.want, buffy.String()); diff != "" {
+			t.Errorf("format %q mismatch (-want +got):\n%s", tv.format, diff)
+		}
+	}
+}
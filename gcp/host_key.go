@@ -0,0 +1,53 @@
+package gcp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/rjkroege/gocloud/config"
+	"golang.org/x/crypto/ssh"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// hostKeyMetadataKey is the metadata key holding the private host key
+// that gocloud makes for a node. The node's boot configuration installs
+// it as the ssh server's host key so that gocloud knows the node's host
+// key before ever connecting to it.
+const hostKeyMetadataKey = config.HostKeyMetadataKey
+
+// makeHostKey makes a new host key and returns it in OpenSSH PEM format.
+func makeHostKey() (string, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("can't make host key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return "", fmt.Errorf("can't marshal host key: %v", err)
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// parseHostKey returns the public half of the PEM format host key.
+func parseHostKey(key string) (ssh.PublicKey, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("bad %s: %v", hostKeyMetadataKey, err)
+	}
+	return signer.PublicKey(), nil
+}
+
+// instanceHostKey returns the public host key delivered to inst or nil
+// if inst was made before gocloud delivered host keys.
+func instanceHostKey(inst *compute.Instance) (ssh.PublicKey, error) {
+	if inst.Metadata != nil {
+		for _, it := range inst.Metadata.Items {
+			if it.Key == hostKeyMetadataKey && it.Value != nil {
+				return parseHostKey(*it.Value)
+			}
+		}
+	}
+	return nil, nil
+}
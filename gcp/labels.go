@@ -0,0 +1,113 @@
+package gcp
+
+import (
+	"fmt"
+	"os/user"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/rjkroege/gocloud/config"
+)
+
+// The labels that gocloud gives every node it makes. They identify the
+// node's owner and origin in ls and in billing exports.
+const (
+	configLabel  = config.ReservedLabelPrefix + "config"
+	userLabel    = config.ReservedLabelPrefix + "user"
+	createdLabel = config.ReservedLabelPrefix + "created"
+	versionLabel = config.ReservedLabelPrefix + "version"
+)
+
+// createdLabelFormat formats the creation time in the characters that a
+// label value allows.
+const createdLabelFormat = "20060102t150405z"
+
+// labelValue returns s with the characters that a label value can't hold
+// replaced by underscores.
+func labelValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, s)
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+// gocloudVersion returns the version of the running gocloud binary.
+func gocloudVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	return "devel"
+}
+
+// currentUserLabel returns the value of the user label for nodes made
+// by the current user.
+func currentUserLabel() (string, error) {
+	userinfo, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("can't get user: %v", err)
+	}
+	return labelValue(userinfo.Username), nil
+}
+
+// makeLabels returns the labels of a node made now from the instance
+// configuration configName, ic.
+func makeLabels(configName string, ic config.InstanceConfig) (map[string]string, error) {
+	username, err := currentUserLabel()
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string, len(ic.Labels)+4)
+	for k, v := range ic.Labels {
+		labels[k] = v
+	}
+	labels[configLabel] = labelValue(configName)
+	labels[userLabel] = username
+	labels[createdLabel] = time.Now().UTC().Format(createdLabelFormat)
+	labels[versionLabel] = labelValue(gocloudVersion())
+	return labels, nil
+}
+
+// LabelFilter selects the nodes that have all of its labels.
+type LabelFilter map[string]string
+
+// ParseLabelFilter makes a LabelFilter from key=value label arguments.
+// mine adds the user label of the current user.
+func ParseLabelFilter(labels []string, mine bool) (LabelFilter, error) {
+	filter := make(LabelFilter)
+	for _, l := range labels {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("label %q is not key=value", l)
+		}
+		filter[k] = v
+	}
+	if mine {
+		username, err := currentUserLabel()
+		if err != nil {
+			return nil, err
+		}
+		filter[userLabel] = username
+	}
+	return filter, nil
+}
+
+// Match returns true if labels has every label in f.
+func (f LabelFilter) Match(labels map[string]string) bool {
+	for k, v := range f {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
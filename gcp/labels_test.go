@@ -0,0 +1,111 @@
+package gcp
+
+import (
+	"os/user"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestMakeNodeLabels(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["billed"] = config.InstanceConfig{
+		Extends: "small",
+		Labels:  map[string]string{"team": "infra", "cost-center": "cc_42"},
+	}
+
+	before := time.Now().UTC().Truncate(time.Second)
+	if _, err := c.MakeNode("billed", "ledger"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	labels := fake.Instance("us-east1-b", "ledger").Labels
+
+	created, err := time.Parse(createdLabelFormat, labels[createdLabel])
+	if err != nil {
+		t.Fatalf("bad %s label %q: %v", createdLabel, labels[createdLabel], err)
+	}
+	if created.Before(before) || created.After(time.Now()) {
+		t.Errorf("%s label %v isn't the creation time", createdLabel, created)
+	}
+	delete(labels, createdLabel)
+
+	userinfo, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{
+		"team":        "infra",
+		"cost-center": "cc_42",
+		configLabel:   "billed",
+		userLabel:     labelValue(userinfo.Username),
+		versionLabel:  labelValue(gocloudVersion()),
+	}, labels); diff != "" {
+		t.Errorf("labels mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"rjkroege", "rjkroege"},
+		{"Jane.Doe", "jane_doe"},
+		{"v1.2.3", "v1_2_3"},
+		{"DOMAIN\\user", "domain_user"},
+	} {
+		if got := labelValue(tc.in); got != tc.want {
+			t.Errorf("labelValue(%q) got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNodesLabelFilter(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	if _, err := c.MakeNode("small", "mine"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name:        "theirs",
+		MachineType: "zones/us-east1-b/machineTypes/e2-small",
+		Labels:      map[string]string{userLabel: "someone-else", configLabel: "small"},
+	})
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name:        "unlabelled",
+		MachineType: "zones/us-east1-b/machineTypes/e2-small",
+	})
+
+	mine, err := ParseLabelFilter(nil, true)
+	if err != nil {
+		t.Fatalf("ParseLabelFilter: %v", err)
+	}
+	small, err := ParseLabelFilter([]string{configLabel + "=small"}, false)
+	if err != nil {
+		t.Fatalf("ParseLabelFilter: %v", err)
+	}
+	if _, err := ParseLabelFilter([]string{"team"}, false); err == nil {
+		t.Error("ParseLabelFilter should reject a label without a value")
+	}
+
+	for _, tc := range []struct {
+		filter LabelFilter
+		want   []string
+	}{
+		{nil, []string{"mine", "theirs", "unlabelled"}},
+		{mine, []string{"mine"}},
+		{small, []string{"mine", "theirs"}},
+	} {
+		nodes, err := c.Nodes(tc.filter)
+		if err != nil {
+			t.Fatalf("Nodes: %v", err)
+		}
+		got := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			got = append(got, n.Name)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Nodes(%v) mismatch (-want +got):\n%s", tc.filter, diff)
+		}
+	}
+}
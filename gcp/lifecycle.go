@@ -0,0 +1,133 @@
+package gcp
+
+import (
+	"fmt"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Stop stops the node called name in zone and waits for it to stop. An
+// empty zone means the zone that contains the node.
+func (c *Client) Stop(zone, name string) error {
+	return c.changeState(zone, name, func(zone string) (*compute.Operation, error) {
+		return c.service.Instances.Stop(c.ProjectId, zone, name).Context(c.ctx).Do()
+	})
+}
+
+// Suspend suspends the node called name in zone and waits for it to be
+// suspended.
+func (c *Client) Suspend(zone, name string) error {
+	return c.changeState(zone, name, func(zone string) (*compute.Operation, error) {
+		return c.service.Instances.Suspend(c.ProjectId, zone, name).Context(c.ctx).Do()
+	})
+}
+
+// Start starts the stopped node called name in zone, waits for it to be
+// running and returns a NodeInfo describing how to reach it.
+func (c *Client) Start(zone, name string) (*NodeInfo, error) {
+	return c.restart(zone, name, func(zone string) (*compute.Operation, error) {
+		return c.service.Instances.Start(c.ProjectId, zone, name).Context(c.ctx).Do()
+	})
+}
+
+// Resume resumes the suspended node called name in zone, waits for it
+// to be running and returns a NodeInfo describing how to reach it.
+func (c *Client) Resume(zone, name string) (*NodeInfo, error) {
+	return c.restart(zone, name, func(zone string) (*compute.Operation, error) {
+		return c.service.Instances.Resume(c.ProjectId, zone, name).Context(c.ctx).Do()
+	})
+}
+
+// Reset resets the node called name in zone, waits for the reset to
+// finish and returns a NodeInfo describing how to reach it.
+func (c *Client) Reset(zone, name string) (*NodeInfo, error) {
+	return c.restart(zone, name, func(zone string) (*compute.Operation, error) {
+		return c.service.Instances.Reset(c.ProjectId, zone, name).Context(c.ctx).Do()
+	})
+}
+
+// changeState runs the operation made by call on the node called name
+// and waits for it to finish.
+func (c *Client) changeState(zone, name string, call func(zone string) (*compute.Operation, error)) error {
+	zone, err := c.resolveZone(zone, name)
+	if err != nil {
+		return err
+	}
+
+	op, err := call(zone)
+	if err != nil {
+		return fmt.Errorf("can't change state of %s: %v", name, err)
+	}
+	return c.waitForOperation(op)
+}
+
+// restart is changeState for operations after which the node is
+// running, possibly with a new ephemeral address.
+func (c *Client) restart(zone, name string, call func(zone string) (*compute.Operation, error)) (*NodeInfo, error) {
+	zone, err := c.resolveZone(zone, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.changeState(zone, name, call); err != nil {
+		return nil, err
+	}
+
+	inst, err := c.Describe(zone, name)
+	if err != nil {
+		return nil, err
+	}
+	if inst.Status != "RUNNING" {
+		return nil, fmt.Errorf("%s is %s, not RUNNING", name, inst.Status)
+	}
+	return c.nodeInfo(inst)
+}
+
+// noTokenError is the error of nodeInfo for an instance without an
+// instancetoken, which gocloud didn't make.
+type noTokenError struct {
+	name string
+}
+
+func (e *noTokenError) Error() string {
+	return fmt.Sprintf("%s has no instancetoken: was it made by gocloud?", e.name)
+}
+
+// nodeInfo makes a NodeInfo for connecting to the running instance inst.
+func (c *Client) nodeInfo(inst *compute.Instance) (*NodeInfo, error) {
+	var token string
+	if inst.Metadata != nil {
+		for _, it := range inst.Metadata.Items {
+			if it.Key == "instancetoken" && it.Value != nil {
+				token = *it.Value
+			}
+		}
+	}
+	if token == "" {
+		return nil, &noTokenError{name: inst.Name}
+	}
+
+	ip, err := getExternalIP(inst)
+	if err != nil {
+		return nil, err
+	}
+
+	ni := &NodeInfo{
+		Name:       inst.Name,
+		ConfigName: instanceConfigName(inst),
+		Addr:       ip,
+		Token:      token,
+		Port:       c.settings.SshPort,
+		Arch:       machineArch(inst.MachineType),
+	}
+	if !hasExternalAccess(inst) {
+		// Without an external address, the node is only reachable
+		// through its configuration's jump host.
+		if ic, err := c.settings.Instance(ni.ConfigName); err == nil {
+			ni.JumpHost = ic.JumpHost
+		}
+	}
+	if ni.HostKey, err = instanceHostKey(inst); err != nil {
+		return nil, err
+	}
+	return ni, nil
+}
@@ -0,0 +1,85 @@
+package gcp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStopStart(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	made, err := c.MakeNode("western", "sleeper")
+	if err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+
+	if err := c.Stop("", "sleeper"); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if got, want := fake.Instance("us-west1-a", "sleeper").Status, "TERMINATED"; got != want {
+		t.Errorf("Stop left status %s, want %s", got, want)
+	}
+
+	if _, err := c.Reset("", "sleeper"); err == nil {
+		t.Error("Reset of a stopped node should fail")
+	}
+
+	ni, err := c.Start("", "sleeper")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got, want := fake.Instance("us-west1-a", "sleeper").Status, "RUNNING"; got != want {
+		t.Errorf("Start left status %s, want %s", got, want)
+	}
+	if ni.Addr == made.Addr {
+		t.Errorf("Start kept the old ephemeral address %s", ni.Addr)
+	}
+	if got, want := ni.Token, made.Token; got != want {
+		t.Errorf("Start NodeInfo.Token got %q, want %q", got, want)
+	}
+	if got, want := ni.ConfigName, "western"; got != want {
+		t.Errorf("Start NodeInfo.ConfigName got %q, want %q", got, want)
+	}
+	if ni.HostKey == nil || !bytes.Equal(ni.HostKey.Marshal(), made.HostKey.Marshal()) {
+		t.Error("Start NodeInfo.HostKey doesn't match the key made with the node")
+	}
+
+	if _, err := c.Reset("", "sleeper"); err != nil {
+		t.Errorf("Reset: %v", err)
+	}
+}
+
+func TestSuspendResume(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	made, err := c.MakeNode("small", "napper")
+	if err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+
+	if _, err := c.Resume("", "napper"); err == nil {
+		t.Error("Resume of a running node should fail")
+	}
+
+	if err := c.Suspend("", "napper"); err != nil {
+		t.Fatalf("Suspend: %v", err)
+	}
+	if got, want := fake.Instance("us-east1-b", "napper").Status, "SUSPENDED"; got != want {
+		t.Errorf("Suspend left status %s, want %s", got, want)
+	}
+
+	ni, err := c.Resume("", "napper")
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if ni.Addr == "" || ni.Addr == made.Addr {
+		t.Errorf("Resume NodeInfo.Addr got %q, want a new address", ni.Addr)
+	}
+	if got, want := ni.Token, made.Token; got != want {
+		t.Errorf("Resume NodeInfo.Token got %q, want %q", got, want)
+	}
+
+	if err := c.Stop("", "missing"); err == nil {
+		t.Error("Stop of a missing node should fail")
+	}
+}
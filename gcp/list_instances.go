@@ -1,71 +1,203 @@
 package gcp
 
 import (
+	"context"
 	"fmt"
-	"net/url"
+	"io"
+	"os"
 	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/rjkroege/gocloud/config"
 	compute "google.golang.org/api/compute/v1"
 )
 
-func getInstances(settings *config.Settings) (*compute.InstanceList, error) {
-	_, client, err := NewAuthenticatedClient([]string{
-		compute.ComputeScope,
+// List returns the instances in every zone of the Client's project
+// sorted by name and zone.
+func (c *Client) List() ([]*compute.Instance, error) {
+	instances := make([]*compute.Instance, 0)
+	if err := c.service.Instances.AggregatedList(c.ProjectId).Pages(c.ctx, func(res *compute.InstanceAggregatedList) error {
+		for _, sl := range res.Items {
+			instances = append(instances, sl.Instances...)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("getting instance list failed: %v", err)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Name != instances[j].Name {
+			return instances[i].Name < instances[j].Name
+		}
+		return instances[i].Zone < instances[j].Zone
 	})
+	return instances, nil
+}
+
+// GetNodeIp returns the address of wantednode: its external IP address
+// or, for a node configured without one, its internal IP address. It
+// returns the empty string if there's no node called wantednode and an
+// error if the node has no address, e.g. because it is stopped.
+func (c *Client) GetNodeIp(wantednode string) (string, error) {
+	instances, err := c.List()
 	if err != nil {
-		return nil, fmt.Errorf("NewAuthenticatedClient failed: %v", err)
+		return "", err
 	}
 
-	service, err := compute.New(client)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to create Compute service: %v", err)
+	for _, inst := range instances {
+		if inst.Name == wantednode {
+			return getExternalIP(inst)
+		}
 	}
 
-	projectId := settings.ProjectId
-	// TODO(rjk): Support multiple zones correctly.
-	zone := settings.DefaultZone
+	// It's not an error that the node isn't up.
+	return "", nil
+}
+
+// Node summarizes an instance for listing. Its json and yaml
+// representations are a stable interface for scripts.
+type Node struct {
+	Name        string    `json:"name" yaml:"name"`
+	Zone        string    `json:"zone" yaml:"zone"`
+	Status      string    `json:"status" yaml:"status"`
+	MachineType string    `json:"machineType" yaml:"machineType"`
+	Created     time.Time `json:"created" yaml:"created"`
 
-	// List the current instances.
-	return service.Instances.List(projectId, zone).Do()
+	// ConfigName is the gocloud configuration that the node was made
+	// from or empty if it can't be determined.
+	ConfigName string `json:"config" yaml:"config"`
+
+	// Addr is the node's external IP address or, for a node configured
+	// without one, its internal IP address. It is empty if the node has
+	// no address, e.g. because it is stopped.
+	Addr string `json:"addr" yaml:"addr"`
+
+	// Preempted is true when the node is stopped because Compute Engine
+	// preempted it.
+	Preempted bool `json:"preempted" yaml:"preempted"`
+
+	// Labels are the node's labels.
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
 }
 
-func List(settings *config.Settings) error {
-	res, err := getInstances(settings)
-	if err != nil {
-		return fmt.Errorf("getting instance list failed: %v", err)
+// summarizeInstance makes a Node from inst.
+func summarizeInstance(inst *compute.Instance) *Node {
+	n := &Node{
+		Name:        inst.Name,
+		Zone:        path.Base(inst.Zone),
+		Status:      inst.Status,
+		MachineType: path.Base(inst.MachineType),
+		ConfigName:  instanceConfigName(inst),
+		Labels:      inst.Labels,
 	}
+	if t, err := time.Parse(time.RFC3339, inst.CreationTimestamp); err == nil {
+		n.Created = t
+	}
+	if ip, err := getExternalIP(inst); err == nil {
+		n.Addr = ip
+	}
+	return n
+}
 
-	for _, inst := range res.Items {
-		machurl, err := url.Parse(inst.MachineType)
-		if err != nil {
-			fmt.Printf("%s has unparsable machine type %s\n", inst.Name, inst.MachineType)
-			continue
+// instanceConfigName returns the name of the gocloud configuration used
+// to make inst. Nodes made before gocloud recorded the configuration in
+// the metadata may still have the gocloud-config label or the default
+// description that names it.
+func instanceConfigName(inst *compute.Instance) string {
+	if inst.Metadata != nil {
+		for _, it := range inst.Metadata.Items {
+			if it.Key == configNameKey && it.Value != nil {
+				return *it.Value
+			}
 		}
+	}
+
+	if name, ok := inst.Labels[configLabel]; ok {
+		return name
+	}
 
-		ip, err := getExternalIP(inst)
-		if err != nil {
-			fmt.Printf("can't determine ip for %s\n", inst.Name)
+	// See Settings.Description for the format.
+	desc := strings.TrimPrefix(inst.Description, inst.Name+": ")
+	if f := strings.Fields(desc); desc != inst.Description && len(f) == 4 && f[3] == "instance" {
+		return f[0]
+	}
+	return ""
+}
+
+// Nodes returns a summary of every instance in the Client's project that
+// filter selects.
+func (c *Client) Nodes(filter LabelFilter) ([]*Node, error) {
+	instances, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	preempted, err := c.preemptions()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*Node, 0, len(instances))
+	for _, inst := range instances {
+		if !filter.Match(inst.Labels) {
 			continue
 		}
+		n := summarizeInstance(inst)
+		n.Preempted = wasPreempted(inst, preempted)
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
 
-		fmt.Println(inst.Name, path.Base(machurl.Path), ip)
+// orDash returns s or "-" if s is empty so that table columns line up.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
 	}
-	return nil
+	return s
 }
 
-func GetNodeIp(settings *config.Settings, wantednode string) (string, error) {
-	res, err := getInstances(settings)
+// formatCreated formats t for a table.
+func formatCreated(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// List prints every node that filter selects in format.
+func List(settings *config.Settings, format *Format, filter LabelFilter) error {
+	c, err := NewClient(context.Background(), settings)
 	if err != nil {
-		return "", fmt.Errorf("getting instance list failed: %v", err)
+		return err
 	}
 
-	for _, inst := range res.Items {
-		if inst.Name == wantednode {
-			return getExternalIP(inst)
-		}
+	nodes, err := c.Nodes(filter)
+	if err != nil {
+		return err
 	}
 
-	// It's not an error that the node isn't up.
-	return "", nil
+	return format.write(os.Stdout, nodes, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tZONE\tSTATUS\tMACHINE\tCREATED\tCONFIG\tIP")
+		for _, n := range nodes {
+			status := n.Status
+			if n.Preempted {
+				status = "PREEMPTED"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", n.Name, n.Zone, status, n.MachineType, formatCreated(n.Created), orDash(n.ConfigName), orDash(n.Addr))
+		}
+		return tw.Flush()
+	})
+}
+
+func GetNodeIp(settings *config.Settings, wantednode string) (string, error) {
+	c, err := NewClient(context.Background(), settings)
+	if err != nil {
+		return "", err
+	}
+	return c.GetNodeIp(wantednode)
 }
@@ -0,0 +1,108 @@
+package gcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func natInterface() []*compute.NetworkInterface {
+	return []*compute.NetworkInterface{
+		{AccessConfigs: []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}},
+	}
+}
+
+func TestList(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name:              "beta",
+		MachineType:       "zones/us-east1-b/machineTypes/e2-small",
+		NetworkInterfaces: natInterface(),
+	})
+	fake.AddInstance("us-west1-a", &compute.Instance{
+		Name:              "alpha",
+		MachineType:       "zones/us-west1-a/machineTypes/e2-medium",
+		NetworkInterfaces: natInterface(),
+	})
+
+	instances, err := c.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if got, want := len(instances), 2; got != want {
+		t.Fatalf("List got %d instances, want %d", got, want)
+	}
+	if got, want := instances[0].Name, "alpha"; got != want {
+		t.Errorf("first instance got %q, want %q", got, want)
+	}
+
+	ip, err := c.GetNodeIp("alpha")
+	if err != nil {
+		t.Fatalf("GetNodeIp: %v", err)
+	}
+	if got, want := ip, fake.Instance("us-west1-a", "alpha").NetworkInterfaces[0].AccessConfigs[0].NatIP; got != want {
+		t.Errorf("GetNodeIp got %q, want %q", got, want)
+	}
+
+	ip, err = c.GetNodeIp("gamma")
+	if err != nil || ip != "" {
+		t.Errorf("GetNodeIp of a missing node got %q, %v, want empty", ip, err)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	if _, err := c.MakeNode("western", "made"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name:        "old",
+		Description: "old: small cos-cloud e2-small instance",
+		MachineType: "zones/us-east1-b/machineTypes/e2-small",
+		Status:      "TERMINATED",
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{Network: "global/networks/default"},
+		},
+	})
+
+	nodes, err := c.Nodes(nil)
+	if err != nil {
+		t.Fatalf("Nodes: %v", err)
+	}
+	// The fake sets the creation time.
+	for _, n := range nodes {
+		if n.Created.IsZero() {
+			t.Errorf("Nodes didn't parse the creation time of %s", n.Name)
+		}
+		n.Created = time.Time{}
+	}
+
+	made := fake.Instance("us-west1-a", "made")
+	if diff := cmp.Diff([]*Node{
+		{
+			Name:        "made",
+			Zone:        "us-west1-a",
+			Status:      "RUNNING",
+			MachineType: "e2-medium",
+			ConfigName:  "western",
+			Addr:        made.NetworkInterfaces[0].AccessConfigs[0].NatIP,
+			Labels:      made.Labels,
+		},
+		{
+			Name:        "old",
+			Zone:        "us-east1-b",
+			Status:      "TERMINATED",
+			MachineType: "e2-small",
+			ConfigName:  "small",
+			// Without an external address, a node's address is its
+			// internal one.
+			Addr: fake.Instance("us-east1-b", "old").NetworkInterfaces[0].NetworkIP,
+		},
+	}, nodes); diff != "" {
+		t.Errorf("Nodes mismatch (-want +got):\n%s", diff)
+	}
+}
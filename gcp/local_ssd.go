@@ -0,0 +1,130 @@
+package gcp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultLocalSsdMount is where local SSDs are mounted by default.
+const defaultLocalSsdMount = "/mnt/disks/localssd"
+
+// localSsds makes n local NVMe SSDs for a node in zone.
+func (c *Client) localSsds(zone string, n int) []*compute.AttachedDisk {
+	disks := make([]*compute.AttachedDisk, 0, n)
+	for i := 0; i < n; i++ {
+		disks = append(disks, &compute.AttachedDisk{
+			AutoDelete: true,
+			Interface:  "NVME",
+			Type:       "SCRATCH",
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				DiskType: c.diskTypeURL(zone, "local-ssd"),
+			},
+		})
+	}
+	return disks
+}
+
+// localSsdBootCmds returns the cloud-config bootcmd entries that format
+// the n local SSDs of a node and mount them at mount. Several SSDs are
+// striped together into one RAID 0 device. The SSDs are blank after the
+// node stops so the commands run on every boot.
+func localSsdBootCmds(n int, mount string) []string {
+	if mount == "" {
+		mount = defaultLocalSsdMount
+	}
+
+	dev := "/dev/disk/by-id/google-local-nvme-ssd-0"
+	cmds := []string{}
+	if n > 1 {
+		devs := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			devs = append(devs, fmt.Sprintf("/dev/disk/by-id/google-local-nvme-ssd-%d", i))
+		}
+		dev = "/dev/md0"
+		cmds = append(cmds, fmt.Sprintf("[ -e %s ] || mdadm --create %s --level=0 --raid-devices=%d %s", dev, dev, n, strings.Join(devs, " ")))
+	}
+	return append(cmds,
+		fmt.Sprintf("blkid %s || mkfs.ext4 -F %s", dev, dev),
+		fmt.Sprintf("mkdir -p %s", mount),
+		fmt.Sprintf("mountpoint -q %s || mount -o discard,defaults %s %s", mount, dev, mount),
+		fmt.Sprintf("chmod a+w %s", mount),
+	)
+}
+
+// bootcmdRegexp matches the top-level bootcmd key of a cloud-config.
+var bootcmdRegexp = regexp.MustCompile(`(?m)^bootcmd:[ \t]*(#.*)?$`)
+
+// addLocalSsdBootCmds adds the bootcmd entries for the local SSDs of ic
+// to the cloud-config userdata. It inserts them before the existing
+// bootcmd entries, or adds a bootcmd list at the end, and leaves the
+// rest of userdata as it is.
+func addLocalSsdBootCmds(userdata string, ic config.InstanceConfig) (string, error) {
+	if ic.LocalSsds == 0 {
+		return userdata, nil
+	}
+	if !strings.HasPrefix(userdata, "#cloud-config") {
+		return "", fmt.Errorf("localssds needs #cloud-config userdata")
+	}
+
+	var cc yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(userdata), &cc); err != nil {
+		return "", fmt.Errorf("can't add local SSD bootcmd to userdata: %v", err)
+	}
+	hasBootcmd := false
+	for _, it := range cc {
+		if it.Key != "bootcmd" {
+			continue
+		}
+		if _, ok := it.Value.([]interface{}); !ok && it.Value != nil {
+			return "", fmt.Errorf("can't add local SSD bootcmd to userdata: bootcmd is not a list")
+		}
+		hasBootcmd = true
+	}
+
+	b, err := yaml.Marshal(localSsdBootCmds(ic.LocalSsds, ic.LocalSsdMount))
+	if err != nil {
+		return "", fmt.Errorf("can't add local SSD bootcmd to userdata: %v", err)
+	}
+	cmds := string(b)
+
+	if !hasBootcmd {
+		if !strings.HasSuffix(userdata, "\n") {
+			userdata += "\n"
+		}
+		return userdata + "bootcmd:\n" + cmds, nil
+	}
+
+	loc := bootcmdRegexp.FindStringIndex(userdata)
+	if loc == nil {
+		return "", fmt.Errorf("can't add local SSD bootcmd to userdata: bootcmd is not a block list")
+	}
+	head, rest := userdata[:loc[1]], userdata[loc[1]:]
+	if !strings.HasPrefix(rest, "\n") {
+		rest = "\n" + rest
+	}
+	// Mount the SSDs before running the configuration's own commands,
+	// indented like them.
+	indent := ""
+	for _, l := range strings.Split(rest, "\n") {
+		t := strings.TrimLeft(l, " ")
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		if strings.HasPrefix(t, "-") {
+			indent = l[:len(l)-len(t)]
+		}
+		break
+	}
+	lines := strings.SplitAfter(cmds, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = indent + l
+		}
+	}
+	return head + "\n" + strings.Join(lines, "") + rest[1:], nil
+}
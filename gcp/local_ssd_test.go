@@ -0,0 +1,113 @@
+package gcp
+
+import (
+	"path"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/gocloud/config"
+)
+
+func TestAddLocalSsdBootCmds(t *testing.T) {
+	for _, tv := range []struct {
+		userdata string
+		ic       config.InstanceConfig
+		want     string
+	}{
+		{"#cloud-config\nruncmd:\n- echo hi\n", config.InstanceConfig{}, "#cloud-config\nruncmd:\n- echo hi\n"},
+		{
+			"#cloud-config\nruncmd:\n- echo hi\n",
+			config.InstanceConfig{LocalSsds: 1},
+			`#cloud-config
+runcmd:
+- echo hi
+bootcmd:
+- blkid /dev/disk/by-id/google-local-nvme-ssd-0 || mkfs.ext4 -F /dev/disk/by-id/google-local-nvme-ssd-0
+- mkdir -p /mnt/disks/localssd
+- mountpoint -q /mnt/disks/localssd || mount -o discard,defaults /dev/disk/by-id/google-local-nvme-ssd-0
+  /mnt/disks/localssd
+- chmod a+w /mnt/disks/localssd
+`,
+		},
+		{
+			"#cloud-config\nbootcmd:\n- echo hello\n",
+			config.InstanceConfig{LocalSsds: 2, LocalSsdMount: "/scratch"},
+			`#cloud-config
+bootcmd:
+- '[ -e /dev/md0 ] || mdadm --create /dev/md0 --level=0 --raid-devices=2 /dev/disk/by-id/google-local-nvme-ssd-0
+  /dev/disk/by-id/google-local-nvme-ssd-1'
+- blkid /dev/md0 || mkfs.ext4 -F /dev/md0
+- mkdir -p /scratch
+- mountpoint -q /scratch || mount -o discard,defaults /dev/md0 /scratch
+- chmod a+w /scratch
+- echo hello
+`,
+		},
+		{
+			"#cloud-config\n# Keep this comment.\nbootcmd:  # early\n  # first\n  - \"echo hello\"\nruncmd: [\"echo hi\"]",
+			config.InstanceConfig{LocalSsds: 1},
+			`#cloud-config
+# Keep this comment.
+bootcmd:  # early
+  - blkid /dev/disk/by-id/google-local-nvme-ssd-0 || mkfs.ext4 -F /dev/disk/by-id/google-local-nvme-ssd-0
+  - mkdir -p /mnt/disks/localssd
+  - mountpoint -q /mnt/disks/localssd || mount -o discard,defaults /dev/disk/by-id/google-local-nvme-ssd-0
+    /mnt/disks/localssd
+  - chmod a+w /mnt/disks/localssd
+  # first
+  - "echo hello"
+runcmd: ["echo hi"]`,
+		},
+	} {
+		got, err := addLocalSsdBootCmds(tv.userdata, tv.ic)
+		if err != nil {
+			t.Fatalf("addLocalSsdBootCmds(%q, %+v): %v", tv.userdata, tv.ic, err)
+		}
+		if diff := cmp.Diff(tv.want, got); diff != "" {
+			t.Errorf("addLocalSsdBootCmds(%q, %+v) mismatch (-want +got):\n%s", tv.userdata, tv.ic, diff)
+		}
+	}
+
+	if _, err := addLocalSsdBootCmds("#!/bin/sh\n", config.InstanceConfig{LocalSsds: 1}); err == nil {
+		t.Error("addLocalSsdBootCmds of a shell script should fail")
+	}
+	if _, err := addLocalSsdBootCmds("#cloud-config\nbootcmd: echo hi\n", config.InstanceConfig{LocalSsds: 1}); err == nil {
+		t.Error("addLocalSsdBootCmds with a bootcmd that isn't a list should fail")
+	}
+}
+
+func TestMakeNodeBootDisk(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["fast"] = config.InstanceConfig{
+		Extends:        "small",
+		DiskType:       "hyperdisk-balanced",
+		DiskIops:       5000,
+		DiskThroughput: 250,
+		LocalSsds:      2,
+	}
+
+	if _, err := c.MakeNode("fast", "racer"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	inst := fake.Instance("us-east1-b", "racer")
+	boot := inst.Disks[0].InitializeParams
+	if got, want := path.Base(boot.DiskType), "hyperdisk-balanced"; got != want {
+		t.Errorf("boot disk type got %s, want %s", got, want)
+	}
+	if boot.ProvisionedIops != 5000 || boot.ProvisionedThroughput != 250 {
+		t.Errorf("boot disk got %d IOPS and %d MB/s, want 5000 and 250", boot.ProvisionedIops, boot.ProvisionedThroughput)
+	}
+
+	var ssds []string
+	for _, ad := range inst.Disks[1:] {
+		if ad.Type == "SCRATCH" && ad.Interface == "NVME" {
+			ssds = append(ssds, ad.DeviceName)
+		}
+	}
+	if diff := cmp.Diff([]string{"local-ssd-0", "local-ssd-1"}, ssds); diff != "" {
+		t.Errorf("local SSDs mismatch (-want +got):\n%s", diff)
+	}
+	if fake.Disk("us-east1-b", "racer-root") == nil {
+		t.Error("MakeNode didn't make the boot disk")
+	}
+}
@@ -0,0 +1,199 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// staticAddressDescription is the description of the static addresses
+// that gocloud reserves for nodes. Delete releases only addresses with
+// it so that it leaves addresses reserved by other means alone.
+const staticAddressDescription = "gocloud static address of %s"
+
+// staticAddressName returns the name of the static address reserved for
+// the node called name.
+func staticAddressName(name string) string {
+	return name + "-ip"
+}
+
+// zoneRegion returns the region that holds zone.
+func zoneRegion(zone string) (string, error) {
+	i := strings.LastIndex(zone, "-")
+	if i <= 0 {
+		return "", fmt.Errorf("zone %q isn't in a region", zone)
+	}
+	return zone[:i], nil
+}
+
+// resourceURL returns the URL of the project resource given by path
+// unless name, which can be a partial URL itself, contains a slash.
+func (c *Client) resourceURL(name, path string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	return "https://www.googleapis.com/compute/v1/projects/" + c.ProjectId + "/" + path + name
+}
+
+// makeNetworkInterface makes the network interface of the new node
+// called instanceName in zone with configuration ic, reserving a static
+// address for it if necessary.
+func (c *Client) makeNetworkInterface(zone, instanceName string, ic config.InstanceConfig) (*compute.NetworkInterface, error) {
+	network := ic.Network
+	if network == "" {
+		network = "default"
+	}
+	region, err := zoneRegion(zone)
+	if err != nil {
+		return nil, err
+	}
+	ni := &compute.NetworkInterface{
+		Network: c.resourceURL(network, "global/networks/"),
+	}
+	if ic.Subnetwork != "" {
+		ni.Subnetwork = c.resourceURL(ic.Subnetwork, "regions/"+region+"/subnetworks/")
+	}
+
+	switch ic.ExternalIp {
+	case "", "ephemeral":
+		ni.AccessConfigs = []*compute.AccessConfig{
+			{
+				Type: "ONE_TO_ONE_NAT",
+				Name: "External NAT",
+			},
+		}
+	case "static":
+		ip, err := c.reserveAddress(region, instanceName)
+		if err != nil {
+			return nil, err
+		}
+		ni.AccessConfigs = []*compute.AccessConfig{
+			{
+				Type:  "ONE_TO_ONE_NAT",
+				Name:  "External NAT",
+				NatIP: ip,
+			},
+		}
+	case "none":
+	default:
+		return nil, fmt.Errorf("unknown externalip %q", ic.ExternalIp)
+	}
+	return ni, nil
+}
+
+// reserveAddress reserves a static external address in region for the
+// node called name and returns it.
+func (c *Client) reserveAddress(region, name string) (string, error) {
+	addrname := staticAddressName(name)
+	op, err := c.service.Addresses.Insert(c.ProjectId, region, &compute.Address{
+		Name:        addrname,
+		Description: fmt.Sprintf(staticAddressDescription, name),
+	}).Context(c.ctx).Do()
+	if isAlreadyExists(err) {
+		// It's not this reservation's to release.
+		return "", fmt.Errorf("can't reserve static address %s: %v", addrname, err)
+	}
+	var addr *compute.Address
+	if err != nil {
+		err = fmt.Errorf("can't reserve static address %s: %v", addrname, err)
+	} else if err = c.waitForOperation(op); err == nil {
+		if addr, err = c.service.Addresses.Get(c.ProjectId, region, addrname).Context(c.ctx).Do(); err != nil {
+			err = fmt.Errorf("can't get static address %s: %v", addrname, err)
+		}
+	}
+	if err != nil {
+		// An interrupted reservation may have reserved the address anyway.
+		c.abandonAddress(region, name)
+		return "", err
+	}
+	return addr.Address, nil
+}
+
+// releaseTimeout bounds how long abandonAddress takes.
+var releaseTimeout = time.Minute
+
+// abandonAddress releases the static address in region that gocloud
+// reserved for the node called name after making the node failed. It
+// works even after the cancellation of the Client's context interrupted
+// making the node and logs instead of returning failures because the
+// failure to make the node is what matters.
+func (c *Client) abandonAddress(region, name string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.ctx), releaseTimeout)
+	defer cancel()
+	if err := c.WithContext(ctx).releaseAddress(region, name); err != nil {
+		log.Printf("can't release the static address of %s: %v", name, err)
+	}
+}
+
+// releaseAddress releases the static address in region that gocloud
+// reserved for the node called name, if there is one.
+func (c *Client) releaseAddress(region, name string) error {
+	addrname := staticAddressName(name)
+	addr, err := c.service.Addresses.Get(c.ProjectId, region, addrname).Context(c.ctx).Do()
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't get static address %s: %v", addrname, err)
+	}
+	if addr.Description != fmt.Sprintf(staticAddressDescription, name) {
+		return nil
+	}
+
+	op, err := c.service.Addresses.Delete(c.ProjectId, region, addrname).Context(c.ctx).Do()
+	if err != nil {
+		return fmt.Errorf("can't release static address %s: %v", addrname, err)
+	}
+	return c.waitForOperation(op)
+}
+
+// releaseLeftAddress releases the static address, in any region, that
+// gocloud reserved for the node called name after the node is gone. It
+// returns gone, the error saying that the node is gone, if there's no
+// such address.
+func (c *Client) releaseLeftAddress(name string, gone error) error {
+	addrname := staticAddressName(name)
+	var regions []string
+	if err := c.service.Addresses.AggregatedList(c.ProjectId).Filter(fmt.Sprintf("name = %q", addrname)).Pages(c.ctx, func(res *compute.AddressAggregatedList) error {
+		for _, sl := range res.Items {
+			for _, addr := range sl.Addresses {
+				if addr.Name == addrname && addr.Description == fmt.Sprintf(staticAddressDescription, name) && addr.Status != "IN_USE" {
+					regions = append(regions, path.Base(addr.Region))
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("can't search for static address %s: %v", addrname, err)
+	}
+	if len(regions) == 0 {
+		return gone
+	}
+
+	for _, region := range regions {
+		c.progressf("%s is gone: releasing its static address %s in %s\n", name, addrname, region)
+		if err := c.releaseAddress(region, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasExternalAccess returns true if inst is configured to have an
+// external address, whether or not it currently has one.
+func hasExternalAccess(inst *compute.Instance) bool {
+	for _, ni := range inst.NetworkInterfaces {
+		for _, ac := range ni.AccessConfigs {
+			if ac.Type == "ONE_TO_ONE_NAT" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package gcp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/gocloud/config"
+	"github.com/rjkroege/gocloud/gcp/sshtest"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestZoneRegion(t *testing.T) {
+	for _, tv := range []struct {
+		zone, want string
+		fails      bool
+	}{
+		{"us-east1-b", "us-east1", false},
+		{"europe-west4-a", "europe-west4", false},
+		{"useast1b", "", true},
+		{"-b", "", true},
+		{"", "", true},
+	} {
+		got, err := zoneRegion(tv.zone)
+		if (err != nil) != tv.fails {
+			t.Errorf("zoneRegion(%q) error %v, want failure %v", tv.zone, err, tv.fails)
+		}
+		if got != tv.want {
+			t.Errorf("zoneRegion(%q) got %q, want %q", tv.zone, got, tv.want)
+		}
+	}
+}
+
+func TestMakeNodeStaticIp(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["server"] = config.InstanceConfig{
+		Extends:    "small",
+		Network:    "dev",
+		Subnetwork: "dev-east",
+		Tags:       []string{"http-server", "ssh"},
+		ExternalIp: "static",
+	}
+
+	ni, err := c.MakeNode("server", "web")
+	if err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	addr := fake.Address("us-east1", "web-ip")
+	if addr == nil {
+		t.Fatal("MakeNode didn't reserve a static address")
+	}
+	if got, want := ni.Addr, addr.Address; got != want {
+		t.Errorf("NodeInfo.Addr got %s, want the static address %s", got, want)
+	}
+
+	inst := fake.Instance("us-east1-b", "web")
+	nic := inst.NetworkInterfaces[0]
+	if got, want := nic.Network, "https://www.googleapis.com/compute/v1/projects/testproject/global/networks/dev"; got != want {
+		t.Errorf("Network got %s, want %s", got, want)
+	}
+	if got, want := nic.Subnetwork, "https://www.googleapis.com/compute/v1/projects/testproject/regions/us-east1/subnetworks/dev-east"; got != want {
+		t.Errorf("Subnetwork got %s, want %s", got, want)
+	}
+	if inst.Tags == nil {
+		t.Fatal("MakeNode didn't set network tags")
+	}
+	if diff := cmp.Diff([]string{"http-server", "ssh"}, inst.Tags.Items); diff != "" {
+		t.Errorf("tags mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := c.Delete("", "web"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if fake.Address("us-east1", "web-ip") != nil {
+		t.Error("Delete didn't release the static address")
+	}
+}
+
+func TestDeleteReleasesLeftAddress(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["server"] = config.InstanceConfig{
+		Extends:    "small",
+		ExternalIp: "static",
+	}
+
+	// Each node deletes itself, leaving its address behind. Delete finds
+	// the address with and without the node's zone.
+	for name, zone := range map[string]string{"web": "", "api": "us-east1-b"} {
+		if _, err := c.MakeNode("server", name); err != nil {
+			t.Fatalf("MakeNode: %v", err)
+		}
+		op, err := c.service.Instances.Delete(testProject, "us-east1-b", name).Do()
+		if err != nil {
+			t.Fatalf("can't delete %s: %v", name, err)
+		}
+		if err := c.waitForOperation(op); err != nil {
+			t.Fatal(err)
+		}
+		if fake.Address("us-east1", name+"-ip") == nil {
+			t.Fatalf("deleting %s released its static address", name)
+		}
+
+		if err := c.Delete(zone, name); err != nil {
+			t.Fatalf("Delete(%q, %s) of a node that is gone: %v", zone, name, err)
+		}
+		if fake.Address("us-east1", name+"-ip") != nil {
+			t.Errorf("Delete(%q, %s) didn't release the static address left behind", zone, name)
+		}
+		if err := c.Delete(zone, name); err == nil {
+			t.Errorf("Delete(%q, %s) with nothing left should fail", zone, name)
+		}
+	}
+}
+
+func TestMakeNodeInternal(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["private"] = config.InstanceConfig{
+		Extends:    "small",
+		ExternalIp: "none",
+		JumpHost:   "bastion.example.com",
+	}
+
+	ni, err := c.MakeNode("private", "hidden")
+	if err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	inst := fake.Instance("us-east1-b", "hidden")
+	if got := inst.NetworkInterfaces[0].AccessConfigs; len(got) != 0 {
+		t.Errorf("MakeNode gave an internal node access configs %+v", got)
+	}
+	if got, want := ni.Addr, inst.NetworkInterfaces[0].NetworkIP; got != want {
+		t.Errorf("NodeInfo.Addr got %q, want the internal address %q", got, want)
+	}
+	if got, want := ni.JumpHost, "bastion.example.com"; got != want {
+		t.Errorf("NodeInfo.JumpHost got %q, want %q", got, want)
+	}
+
+	if err := c.Stop("", "hidden"); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	ni, err = c.Start("", "hidden")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got, want := ni.JumpHost, "bastion.example.com"; got != want {
+		t.Errorf("Start NodeInfo.JumpHost got %q, want %q", got, want)
+	}
+}
+
+func TestWaitForSshJumpHost(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	node, settings, ni := newTestSshServer(t, "secret-token")
+
+	authorized, err := os.ReadFile(settings.SshPublicKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(authorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jump, err := sshtest.NewServer(t.TempDir(), pub)
+	if err != nil {
+		t.Fatalf("sshtest.NewServer: %v", err)
+	}
+	t.Cleanup(jump.Close)
+	jump.SetForward(func(addr string) (string, bool) {
+		return node.Addr, addr == "10.128.0.9:22"
+	})
+
+	ni.Addr = "10.128.0.9"
+	ni.Port = 0
+	ni.JumpHost = "jumper@" + jump.Addr
+
+	if _, err := WaitForSsh(settings, ni); err == nil {
+		t.Fatal("WaitForSsh should fail without a known host key for the jump host")
+	}
+
+	knownhostsfile := filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	if err := os.MkdirAll(filepath.Dir(knownhostsfile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(jump.Addr)}, jump.HostKey().PublicKey())
+	if err := os.WriteFile(knownhostsfile, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := WaitForSsh(settings, ni)
+	if err != nil {
+		t.Fatalf("WaitForSsh: %v", err)
+	}
+	defer client.Close()
+	if err := CheckToken(ni, client); err != nil {
+		t.Errorf("CheckToken through the jump host: %v", err)
+	}
+}
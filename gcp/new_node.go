@@ -1,14 +1,17 @@
 package gcp
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"net"
+	"os"
 	"strconv"
 	"time"
 
 	"github.com/rjkroege/gocloud/config"
+	"golang.org/x/crypto/ssh"
 	compute "google.golang.org/api/compute/v1"
-	"google.golang.org/api/googleapi"
 )
 
 func parseDiskSize(szs string) (int64, error) {
@@ -25,142 +28,217 @@ type NodeInfo struct {
 	ConfigName string
 	Addr       string
 	Token      string
+
+	// Port is the node's ssh port. Zero means the standard port.
+	Port int
+
+	// JumpHost, when set, is the ssh jump host, [user@]host[:port],
+	// through which Addr is reachable.
+	JumpHost string
+
+	// Arch is the node's processor architecture, amd64 or arm64.
+	Arch string
+
+	// HostKey is the node's ssh host key. It is nil for nodes made
+	// before gocloud delivered host keys: connections to them rely on
+	// the token check alone.
+	HostKey ssh.PublicKey
 }
 
 // Ssh returns the address for an SSH connection to the node.
 func (ni *NodeInfo) Ssh() string {
-	return ni.Addr + ":22"
+	port := 22
+	if ni.Port != 0 {
+		port = ni.Port
+	}
+	return net.JoinHostPort(ni.Addr, strconv.Itoa(port))
 }
 
 // based on https://github.com/googleapis/google-api-go-client/blob/master/examples/compute.go
 
+// MakeNode makes a new node called instanceName from the configuration
+// configName, reporting progress on stdout.
 func MakeNode(settings *config.Settings, configName, instanceName string) (*NodeInfo, error) {
-	ctx, client, err := NewAuthenticatedClient([]string{
-		compute.ComputeScope,
-	})
+	c, err := NewClient(context.Background(), settings)
 	if err != nil {
-		return nil, fmt.Errorf("NewAuthenticatedClient failed: %v", err)
+		return nil, err
+	}
+	c.Progress = os.Stdout
+	return c.MakeNode(configName, instanceName)
+}
+
+// NotMadeError is a failure of MakeNode that left no node to clean up:
+// it happened before the node was inserted or the insert failed because
+// a node with the same name already exists or its operation failed, e.g.
+// for an exceeded quota. Other failures to insert, e.g. an interrupted
+// insert, may have made the node.
+type NotMadeError struct {
+	Name string
+	Err  error
+}
+
+func (e *NotMadeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NotMadeError) Unwrap() error {
+	return e.Err
+}
+
+// MakeNode makes a new node called instanceName from the configuration
+// configName and waits for it to be running. It returns a *NotMadeError
+// when it fails without making the node.
+func (c *Client) MakeNode(configName, instanceName string) (*NodeInfo, error) {
+	// Nothing is left to clean up after the failures until the node
+	// is inserted.
+	notMade := func(err error) error {
+		return &NotMadeError{Name: instanceName, Err: err}
 	}
 
-	familyName := settings.InstanceTypes[configName].Family
-	latestimage, err := findNewestStableImage(ctx, client, familyName)
+	settings := c.settings
+	ic, err := settings.Instance(configName)
 	if err != nil {
-		return nil, fmt.Errorf("can't find desired stable image", err)
+		return nil, notMade(err)
 	}
 
-	// TODO(rjk): reuse the service.
-	service, err := compute.New(client)
+	image, err := c.configImage(configName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create Compute service: %v", err)
+		return nil, notMade(fmt.Errorf("can't find boot image: %v", err))
 	}
 
-	projectID := settings.ProjectId
+	projectID := c.ProjectId
 	zone := settings.Zone(configName)
 	prefix := "https://www.googleapis.com/compute/v1/projects/" + projectID
-	imageURL := "https://www.googleapis.com/compute/v1/projects/" + familyName + "/global/images/" + latestimage.Name
-
-	machinetype := settings.InstanceTypes[configName].Hardware
+	jumphost := ""
+	if ic.ExternalIp == "none" {
+		jumphost = ic.JumpHost
+	}
+	imageURL := image.SelfLink
 
-	// TODO(rjk): add disk type (e.g. flash, persistent, etc.)
+	machinetype := ic.Hardware
 
-	metadata, err := makeMetadataObject(settings, configName)
+	metadata, err := makeMetadataObject(settings, configName, c.Progress)
 	if err != nil {
-		return nil, fmt.Errorf("can't make metadata: %v", err)
+		return nil, notMade(fmt.Errorf("can't make metadata: %v", err))
+	}
+	var hostkey ssh.PublicKey
+	if ic.HostKey {
+		if hostkey, err = parseHostKey(metadata[hostKeyMetadataKey]); err != nil {
+			return nil, notMade(err)
+		}
 	}
 
 	diskName := fmt.Sprintf("%s-root", instanceName)
 
+	scheduling, err := makeScheduling(ic)
+	if err != nil {
+		return nil, notMade(err)
+	}
+
+	datadisks, err := c.dataDisks(zone, configName, instanceName, ic)
+	if err != nil {
+		return nil, notMade(err)
+	}
+	datadisks = append(datadisks, c.localSsds(zone, ic.LocalSsds)...)
+
+	bootdisk := &compute.AttachedDiskInitializeParams{
+		// TODO(rjk): compute something better
+		DiskName:              diskName,
+		DiskSizeGb:            ic.DiskSize,
+		SourceImage:           imageURL,
+		ProvisionedIops:       ic.DiskIops,
+		ProvisionedThroughput: ic.DiskThroughput,
+	}
+	if ic.DiskType != "" {
+		bootdisk.DiskType = c.diskTypeURL(zone, ic.DiskType)
+	}
+
+	labels, err := makeLabels(configName, ic)
+	if err != nil {
+		return nil, notMade(err)
+	}
+
+	networkinterface, err := c.makeNetworkInterface(zone, instanceName, ic)
+	if err != nil {
+		return nil, notMade(err)
+	}
+
 	instance := &compute.Instance{
 		Name:        instanceName,
 		Description: settings.Description(configName, instanceName),
+		Labels:      labels,
 		MachineType: prefix + "/zones/" + zone + "/machineTypes/" + machinetype,
 
-		Disks: []*compute.AttachedDisk{
-			{
-				AutoDelete: true,
-				Boot:       true,
-				Type:       "PERSISTENT",
-				InitializeParams: &compute.AttachedDiskInitializeParams{
-					// TODO(rjk): compute something better
-					DiskName:    diskName,
-					DiskSizeGb:  settings.InstanceTypes[configName].DiskSize,
-					SourceImage: imageURL,
-				},
-			},
-		},
-		Metadata: convertMapToGcpFormat(metadata),
-		NetworkInterfaces: []*compute.NetworkInterface{
+		Disks: append([]*compute.AttachedDisk{
 			{
-				AccessConfigs: []*compute.AccessConfig{
-					{
-						Type: "ONE_TO_ONE_NAT",
-						Name: "External NAT",
-					},
-				},
-				Network: prefix + "/global/networks/default",
+				AutoDelete:       true,
+				Boot:             true,
+				Type:             "PERSISTENT",
+				InitializeParams: bootdisk,
 			},
-		},
-		ServiceAccounts: []*compute.ServiceAccount{
-			{
-				// TODO(rjk): read this from the config file.
-				Email: "default",
-				Scopes: []string{
-					// TODO(rjk): I have no idea if this will do what I want.
-					compute.DevstorageFullControlScope,
-					compute.ComputeScope,
-					compute.CloudPlatformScope,
-				},
-			},
-		},
+		}, datadisks...),
+		Metadata:          convertMapToGcpFormat(metadata),
+		Scheduling:        scheduling,
+		NetworkInterfaces: []*compute.NetworkInterface{networkinterface},
+		ServiceAccounts:   makeServiceAccounts(ic),
 	}
 
-	// TODO(rjk): check somehow that I've not attempted to remake a node.
-	// Aside: wouldn't trying to make a node more than once fail?
+	if len(ic.Tags) > 0 {
+		instance.Tags = &compute.Tags{Items: ic.Tags}
+	}
 
-	op, err := service.Instances.Insert(projectID, zone, instance).Context(ctx).Do()
-	if err != nil {
+	op, err := c.service.Instances.Insert(projectID, zone, instance).Context(c.ctx).Do()
+	var operr *OperationError
+	switch {
+	case isAlreadyExists(err):
+		// Someone made the node since gocloud make checked for it.
+		err = notMade(fmt.Errorf("%s already exists in %s", instanceName, zone))
+	case err != nil:
+		// An interrupted insert may have made the node anyway so the
+		// caller has to clean up.
 		return nil, fmt.Errorf("instance insertion failed: %v", err)
+	default:
+		err = c.waitForOperation(op)
+		if errors.As(err, &operr) {
+			// An *OperationError, e.g. for an exceeded quota, says what failed.
+			err = notMade(err)
+		} else if err != nil {
+			// Likewise for an interrupted wait.
+			return nil, err
+		}
 	}
-
-	etag := op.Header.Get("Etag")
-	log.Printf("Etag=%#v", etag)
-
-	for i := 0; i < 12; i++ {
-		// Wait a bit for the GCP to have done something.
-		delayms := time.Duration(64*(1<<i)) * time.Millisecond
-		log.Printf("wating %v...", delayms)
-		delay := time.NewTimer(delayms)
-		<-delay.C
-
-		log.Println("polling for the instance running as desired")
-		// TODO(rjk): Could use Fields() here?
-		inst, err := service.Instances.Get(projectID, zone, instanceName).Context(ctx).IfNoneMatch(etag).Do()
-		if err != nil && !googleapi.IsNotModified(err) {
-			// Something went wrong and we should stop trying
-			return nil, fmt.Errorf("getting inserted instance %s failed: %v", instanceName, err)
+	if err != nil {
+		if ic.ExternalIp == "static" {
+			// makeNetworkInterface has checked the zone.
+			region, _ := zoneRegion(zone)
+			c.abandonAddress(region, instanceName)
 		}
+		return nil, err
+	}
 
-		if err == nil {
-			log.Printf("got %q, status %q", inst.Name, inst.Status)
-			// Instance has changed but are we in the right state?
-			ip, err := getExternalIP(inst)
-			if err == nil && inst.Status == "RUNNING" {
-				// Yes, it's running and has an IP.
-				return &NodeInfo{
-					Name:       inst.Name,
-					ConfigName: configName,
-					Addr:       ip,
-					Token:      metadata["instancetoken"],
-				}, nil
-			}
-			// Not in the right state yet. Try again.
-			etag = inst.Header.Get("Etag")
-		}
+	inst, err := c.waitForRunning(zone, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := getExternalIP(inst)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("too many tries failing to get running state for %s", instanceName)
+	return &NodeInfo{
+		Name:       inst.Name,
+		ConfigName: configName,
+		Addr:       ip,
+		Token:      metadata["instancetoken"],
+		Port:       settings.SshPort,
+		JumpHost:   jumphost,
+		Arch:       machineArch(machinetype),
+		HostKey:    hostkey,
+	}, nil
 }
 
-// getExternalIP digs through inst looking for its external (i.e. via NAT) IP
+// getExternalIP digs through inst looking for its external (i.e. via NAT) IP.
+// It falls back to the internal IP of a node configured without an
+// external address.
 func getExternalIP(inst *compute.Instance) (string, error) {
 	// I don't know how much variety that there would be in the structure of the info
 	// I want one external IP. Not necessarily all of them.
@@ -171,5 +249,47 @@ func getExternalIP(inst *compute.Instance) (string, error) {
 			}
 		}
 	}
+	if !hasExternalAccess(inst) {
+		for _, ni := range inst.NetworkInterfaces {
+			if ni.NetworkIP != "" {
+				return ni.NetworkIP, nil
+			}
+		}
+	}
 	return "", fmt.Errorf("%s doesn't have external ip", inst.Name)
 }
+
+// maxRunningPollDelay caps the delay between polls of waitForRunning.
+const maxRunningPollDelay = 4 * time.Second
+
+// waitForRunning waits for the just made instance called name in zone to
+// finish starting. The insert operation can be done while the instance is
+// still PROVISIONING or STAGING. It gives up when c's context is done.
+func (c *Client) waitForRunning(zone, name string) (*compute.Instance, error) {
+	delay := 64 * time.Millisecond
+	for {
+		inst, err := c.service.Instances.Get(c.ProjectId, zone, name).Context(c.ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("getting inserted instance %s failed: %v", name, err)
+		}
+		switch inst.Status {
+		case "RUNNING":
+			return inst, nil
+		case "PROVISIONING", "STAGING":
+		default:
+			return nil, fmt.Errorf("%s is %s after it was made, not RUNNING", name, inst.Status)
+		}
+
+		c.progressf("%s is %s\n", name, inst.Status)
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-c.ctx.Done():
+			t.Stop()
+			return nil, fmt.Errorf("gave up waiting for %s to run: %v", name, c.ctx.Err())
+		}
+		if delay *= 2; delay > maxRunningPollDelay {
+			delay = maxRunningPollDelay
+		}
+	}
+}
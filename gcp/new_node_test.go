@@ -0,0 +1,190 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rjkroege/gocloud/config"
+	"github.com/rjkroege/gocloud/gcp/fakecompute"
+	compute "google.golang.org/api/compute/v1"
+)
+
+const testProject = "testproject"
+
+var testImages = []*compute.Image{
+	{Name: "cos-arm64-stable-105-17412-156-59", Family: "cos-arm64-stable"},
+	{Name: "cos-beta-109-17800-0-15", Family: "cos-beta"},
+	{Name: "cos-stable-105-17412-156-59", Family: "cos-stable"},
+	{Name: "cos-stable-101-17162-40-56", Family: "cos-stable", Deprecated: &compute.DeprecationStatus{State: "DEPRECATED"}},
+}
+
+// newTestClient returns a Client connected to a new fake Compute Engine
+// server and the server itself.
+func newTestClient(t *testing.T) (*Client, *fakecompute.Server) {
+	t.Helper()
+	fake := fakecompute.NewServer(testProject, "us-east1-b", "us-west1-a")
+	t.Cleanup(fake.Close)
+	fake.AddImages("cos-cloud", testImages...)
+
+	pubkey := filepath.Join(t.TempDir(), "id_test.pub")
+	if err := os.WriteFile(pubkey, []byte("ssh-ed25519 AAAAtest test@example\n"), 0600); err != nil {
+		t.Fatalf("can't write test key: %v", err)
+	}
+
+	settings := &config.Settings{
+		DefaultZone:      "us-east1-b",
+		ProjectId:        testProject,
+		SshPublicKeyFile: pubkey,
+		DefaultUserData:  "#cloud-config\n",
+		Endpoint:         fake.Endpoint(),
+		InstanceTypes: map[string]config.InstanceConfig{
+			"small": {
+				Family:   "cos-cloud",
+				Hardware: "e2-small",
+				DiskSize: 20,
+				HostKey:  true,
+			},
+			"western": {
+				Family:   "cos-cloud",
+				Hardware: "e2-medium",
+				Zone:     "us-west1-a",
+				HostKey:  true,
+			},
+		},
+	}
+
+	c, err := NewClient(context.Background(), settings)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c, fake
+}
+
+func TestMakeNode(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	ni, err := c.MakeNode("small", "testnode")
+	if err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+
+	inst := fake.Instance("us-east1-b", "testnode")
+	if inst == nil {
+		t.Fatal("MakeNode didn't insert an instance")
+	}
+
+	if got, want := ni.Addr, inst.NetworkInterfaces[0].AccessConfigs[0].NatIP; got != want {
+		t.Errorf("NodeInfo.Addr got %q, want %q", got, want)
+	}
+	if got, want := ni.ConfigName, "small"; got != want {
+		t.Errorf("NodeInfo.ConfigName got %q, want %q", got, want)
+	}
+	if got, want := inst.MachineType, "https://www.googleapis.com/compute/v1/projects/testproject/zones/us-east1-b/machineTypes/e2-small"; got != want {
+		t.Errorf("MachineType got %q, want %q", got, want)
+	}
+	if got, want := inst.Disks[0].InitializeParams.SourceImage, "https://www.googleapis.com/compute/v1/projects/cos-cloud/global/images/cos-stable-105-17412-156-59"; got != want {
+		t.Errorf("SourceImage got %q, want %q", got, want)
+	}
+	if got, want := inst.Disks[0].InitializeParams.DiskSizeGb, int64(20); got != want {
+		t.Errorf("DiskSizeGb got %d, want %d", got, want)
+	}
+
+	token := ""
+	for _, it := range inst.Metadata.Items {
+		if it.Key == "instancetoken" {
+			token = *it.Value
+		}
+	}
+	if token == "" || token != ni.Token {
+		t.Errorf("instancetoken metadata %q doesn't match NodeInfo.Token %q", token, ni.Token)
+	}
+
+	hostkey, err := instanceHostKey(inst)
+	if err != nil || hostkey == nil {
+		t.Fatalf("no host key in the metadata: %v", err)
+	}
+	if ni.HostKey == nil || !bytes.Equal(hostkey.Marshal(), ni.HostKey.Marshal()) {
+		t.Errorf("sshhostkey metadata doesn't match NodeInfo.HostKey")
+	}
+}
+
+func TestMakeNodeNoHostKey(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["unpinned"] = config.InstanceConfig{
+		Family:   "cos-cloud",
+		Hardware: "e2-small",
+	}
+	ni, err := c.MakeNode("unpinned", "unpinned")
+	if err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	if ni.HostKey != nil {
+		t.Error("MakeNode gave a node without hostkey a NodeInfo.HostKey")
+	}
+	if hostkey, err := instanceHostKey(fake.Instance("us-east1-b", "unpinned")); err != nil || hostkey != nil {
+		t.Errorf("MakeNode delivered a host key to a node without hostkey: %v", err)
+	}
+}
+
+func TestMakeNodeZoneOverride(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	if _, err := c.MakeNode("western", "westnode"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	if fake.Instance("us-west1-a", "westnode") == nil {
+		t.Error("MakeNode didn't use the configuration's zone")
+	}
+}
+
+func TestMakeNodeWaitsForRunning(t *testing.T) {
+	c, fake := newTestClient(t)
+	fake.Staging = 2
+	var progress bytes.Buffer
+	c.Progress = &progress
+
+	if _, err := c.MakeNode("small", "slow"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	gets := 0
+	for _, r := range fake.Requests() {
+		if r == "GET /compute/v1/projects/testproject/zones/us-east1-b/instances/slow" {
+			gets++
+		}
+	}
+	if gets != 3 {
+		t.Errorf("MakeNode got the instance %d times, want 3", gets)
+	}
+	if want := "slow is STAGING\nslow is STAGING\n"; !strings.Contains(progress.String(), want) {
+		t.Errorf("progress got %q, want it to contain %q", progress.String(), want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	c.ctx = ctx
+	fake.Staging = 1000
+	start := time.Now()
+	_, err := c.MakeNode("small", "stuck")
+	if err == nil || !strings.Contains(err.Error(), "gave up waiting for stuck to run") {
+		t.Errorf("MakeNode got error %v, want it to give up at the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("MakeNode took %v to give up", elapsed)
+	}
+}
+
+func TestMakeNodeExists(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	if _, err := c.MakeNode("small", "testnode"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	if _, err := c.MakeNode("small", "testnode"); err == nil {
+		t.Error("making the same node twice should fail")
+	}
+}
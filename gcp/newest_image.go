@@ -3,7 +3,8 @@ package gcp
 import (
 	"context"
 	"fmt"
-	"net/http"
+	"io"
+	"os"
 	"strconv"
 	"strings"
 
@@ -11,40 +12,87 @@ import (
 	compute "google.golang.org/api/compute/v1"
 )
 
-// ListImages lists available and default selected images for each image family
+// ImageSummary describes an available image. Its json and yaml
+// representations are a stable interface for scripts.
+type ImageSummary struct {
+	Name    string `json:"name" yaml:"name"`
+	Family  string `json:"family" yaml:"family"`
+	Project string `json:"project" yaml:"project"`
+
+	// NewestStable is set on the images that gocloud would choose for new
+	// nodes from Project.
+	NewestStable bool `json:"newestStable" yaml:"newestStable"`
+}
+
+// ListImages lists available and default selected images for each image project
 // currently in use.
-func ListImages(settings *config.Settings) error {
-	ctx, client, err := NewAuthenticatedClient([]string{
-		compute.ComputeScope,
-	})
+func ListImages(settings *config.Settings, format *Format) error {
+	c, err := NewClient(context.Background(), settings)
 	if err != nil {
-		return fmt.Errorf("NewAuthenticatedClient failed: %v", err)
+		return err
 	}
 
-	for _, fam := range settings.UniqueFamilies() {
-		notdeprecated, err := listProjectImages(ctx, client, fam)
-		if err != nil {
-			return fmt.Errorf("listProjectImages: %v", err)
-		}
-		// Output the not-deprecated.
-		for _, im := range notdeprecated {
-			// TODO(rjk): have a verbose setting to dump more info?
-			fmt.Println(im.Name, im.Family)
+	chosen := make(map[string]bool)
+	for name := range settings.InstanceTypes {
+		if im, err := c.configImage(name); err != nil {
+			fmt.Fprintf(os.Stderr, "can't find image for %s: %v\n", name, err)
+		} else {
+			chosen[im.SelfLink] = true
 		}
+	}
 
-		neweststable, err := findNewestStableImage(ctx, client, fam)
+	summaries := make([]*ImageSummary, 0)
+	for _, project := range settings.UniqueFamilies() {
+		notdeprecated, err := c.Images(project)
 		if err != nil {
-			fmt.Println("can't find stable image", err)
+			return err
 		}
-		fmt.Println("* newest stable:", neweststable.Name)
 
+		for _, im := range notdeprecated {
+			summaries = append(summaries, &ImageSummary{
+				Name:         im.Name,
+				Family:       im.Family,
+				Project:      project,
+				NewestStable: chosen[im.SelfLink],
+			})
+		}
 	}
 
-	return nil
+	return format.write(os.Stdout, summaries, func(w io.Writer) error {
+		for _, im := range summaries {
+			// TODO(rjk): have a verbose setting to dump more info?
+			fmt.Fprintln(w, im.Name, im.Family)
+		}
+		for _, im := range summaries {
+			if im.NewestStable {
+				fmt.Fprintln(w, "* newest stable:", im.Name)
+			}
+		}
+		return nil
+	})
 }
 
+// VersionTuple is the version of a COS image: its milestone, build,
+// branch and patch numbers.
 type VersionTuple [4]int
 
+// Compare returns -1, 0 or 1 when v is older than, the same as or newer
+// than o.
+func (v VersionTuple) Compare(o VersionTuple) int {
+	for i := range v {
+		switch {
+		case v[i] < o[i]:
+			return -1
+		case v[i] > o[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseCosName returns the channel and version of the COS image called
+// name, e.g. cos-stable-105-17412-156-59. Images without a channel in
+// their name, e.g. cos-105-17412-448-12, are LTS images.
 func parseCosName(name string) (string, VersionTuple, error) {
 	vt := VersionTuple{0, 0, 0, 0}
 
@@ -58,83 +106,133 @@ func parseCosName(name string) (string, VersionTuple, error) {
 	ps = ps[1:]
 
 	// Remove the processor.
-	switch ps[0] {
-	case "arm64":
+	if len(ps) > 0 && ps[0] == "arm64" {
 		ps = ps[1:]
 	}
 
-	switch ps[0] {
-	case "stable", "beta", "dev":
-		channel = ps[0]
-		ps = ps[1:]
-	default:
-		ps = ps[0:]
+	if len(ps) > 0 {
+		switch ps[0] {
+		case "stable", "beta", "dev":
+			channel = ps[0]
+			ps = ps[1:]
+		}
 	}
 
+	if len(ps) != len(vt) {
+		return "", VersionTuple{0, 0, 0, 0}, fmt.Errorf("can't parse version from %q", name)
+	}
 	for i, s := range ps {
 		v, err := strconv.Atoi(s)
 		if err != nil {
-			return "", [4]int{0, 0, 0, 0}, fmt.Errorf("[%d] can't parse int from %q", i, name)
+			return "", VersionTuple{0, 0, 0, 0}, fmt.Errorf("[%d] can't parse int from %q", i, name)
 		}
 		vt[i] = v
 	}
 	return channel, vt, nil
 }
 
-// TODO(rjk): Make this into a function that can be used as a sort comparison
-// function.
-// I think that I didn't need this code. Whatever.
-func newest(v1, v2 VersionTuple) VersionTuple {
-	for i := range v1 {
-		if v1[i] > v2[i] {
-			return v1
-		} else {
-			return v2
-		}
-	}
-	// I think that this means that they're equal
-	return v1
+// NewestStableImage returns the newest stable x86 image in family, the
+// image project. Only cos-cloud is supported.
+func (c *Client) NewestStableImage(family string) (*compute.Image, error) {
+	return c.NewestImage(family, "stable", "amd64")
 }
 
-func findNewestStableImage(ctx context.Context, client *http.Client, family string) (*compute.Image, error) {
+// NewestImage returns the newest image for arch, amd64 or arm64, in
+// channel from family, the image project. Only cos-cloud is supported.
+// See InstanceConfig.Channel for the channels.
+func (c *Client) NewestImage(family, channel, arch string) (*compute.Image, error) {
 	switch family {
 	case "cos-cloud":
-		return findNewestStableCosImage(ctx, client)
+		return c.findNewestCosImage(channel, arch)
 	}
-	return nil, fmt.Errorf("can't finde images for family %q", family)
+	return nil, fmt.Errorf("can't choose images from %q: set imagefamily or image", family)
 }
 
-func findNewestStableCosImage(ctx context.Context, client *http.Client) (*compute.Image, error) {
-	notdeprecated, err := listProjectImages(ctx, client, "cos-cloud")
+// configImage returns the boot image of new nodes made from the
+// instance configuration configName.
+func (c *Client) configImage(configName string) (*compute.Image, error) {
+	ic, err := c.settings.Instance(configName)
 	if err != nil {
-		return nil, fmt.Errorf("listProjectImages: %v", err)
+		return nil, err
 	}
+	project := c.settings.ImageProject(configName)
+	arch := machineArch(ic.Hardware)
 
-	for _, im := range notdeprecated {
-		// Skip arm processors.
-		if strings.Index(im.Name, "arm64") > -1 {
-			continue
+	var im *compute.Image
+	switch {
+	case ic.Image != "":
+		name := ic.Image
+		if i := strings.Index(name, "projects/"); i >= 0 {
+			// projects/<project>/global/images/<name>
+			ps := strings.Split(name[i:], "/")
+			project, name = ps[1], ps[4]
 		}
-		c, _, err := parseCosName(im.Name)
-		if err != nil {
-			return nil, fmt.Errorf("can't parse: %v", err)
+		if im, err = c.service.Images.Get(project, name).Context(c.ctx).Do(); err != nil {
+			return nil, fmt.Errorf("can't get image %s from %s: %v", name, project, err)
 		}
-		if c == "stable" {
-			return im, nil
+	case ic.ImageFamily != "":
+		if im, err = c.service.Images.GetFromFamily(project, ic.ImageFamily).Context(c.ctx).Do(); err != nil {
+			return nil, fmt.Errorf("can't get image family %s from %s: %v", ic.ImageFamily, project, err)
 		}
-		// TODO(rjk): Use newest() to find an lts image as necessary.
+	default:
+		channel := ic.Channel
+		if channel == "" {
+			channel = "stable"
+		}
+		return c.NewestImage(project, channel, arch)
+	}
+
+	// Images made before Compute Engine recorded architectures have none.
+	if im.Architecture != "" && im.Architecture != imageArch(arch) {
+		return nil, fmt.Errorf("image %s is for %s but %s is %s", im.Name, im.Architecture, ic.Hardware, imageArch(arch))
 	}
-	return nil, fmt.Errorf("no stable cos-cloud image")
+	return im, nil
 }
 
-func listProjectImages(ctx context.Context, client *http.Client, project string) ([]*compute.Image, error) {
-	service, err := compute.New(client)
+// findNewestCosImage returns the newest cos-cloud image for arch in
+// channel: stable, beta, dev, lts or lts-<milestone>.
+func (c *Client) findNewestCosImage(channel, arch string) (*compute.Image, error) {
+	wantchannel, milestone := channel, 0
+	if m, ok := strings.CutPrefix(channel, "lts-"); ok {
+		n, err := strconv.Atoi(m)
+		if err != nil {
+			return nil, fmt.Errorf("bad channel %q: %v", channel, err)
+		}
+		wantchannel, milestone = "lts", n
+	}
+
+	notdeprecated, err := c.Images("cos-cloud")
 	if err != nil {
-		return nil, fmt.Errorf("Unable to create Compute service: %v", err)
+		return nil, err
+	}
+
+	var best *compute.Image
+	var bestvt VersionTuple
+	for _, im := range notdeprecated {
+		// Only Arm images have the processor in their names.
+		if strings.Contains(im.Name, "-arm64-") != (arch == "arm64") {
+			continue
+		}
+		// cos-cloud holds images with other naming schemes. They aren't
+		// candidates.
+		ch, vt, err := parseCosName(im.Name)
+		if err != nil || ch != wantchannel || (milestone != 0 && vt[0] != milestone) {
+			continue
+		}
+		if best == nil || vt.Compare(bestvt) > 0 {
+			best, bestvt = im, vt
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no %s %s cos-cloud image", channel, arch)
 	}
+	return best, nil
+}
 
+// Images returns the images in project that have not been deprecated.
+func (c *Client) Images(project string) ([]*compute.Image, error) {
 	// Show the current images that are available.
-	listcommand := service.Images.List(project).Context(ctx)
+	listcommand := c.service.Images.List(project).Context(c.ctx)
 
 	// TODO(rjk): Use a filter operation?
 	notdeprecated := make([]*compute.Image, 0)
@@ -0,0 +1,197 @@
+package gcp
+
+import (
+	"testing"
+
+	"github.com/rjkroege/gocloud/config"
+	"github.com/rjkroege/gocloud/gcp/fakecompute"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestNewestStableImage(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	im, err := c.NewestStableImage("cos-cloud")
+	if err != nil {
+		t.Fatalf("NewestStableImage: %v", err)
+	}
+	if got, want := im.Name, "cos-stable-105-17412-156-59"; got != want {
+		t.Errorf("NewestStableImage got %q, want %q", got, want)
+	}
+
+	images, err := c.Images("cos-cloud")
+	if err != nil {
+		t.Fatalf("Images: %v", err)
+	}
+	if got, want := len(images), 3; got != want {
+		t.Errorf("Images got %d not-deprecated images, want %d", got, want)
+	}
+
+	if _, err := c.NewestStableImage("debian-cloud"); err == nil {
+		t.Error("NewestStableImage of an unsupported family should fail")
+	}
+}
+
+func TestMakeNodeImage(t *testing.T) {
+	c, fake := newTestClient(t)
+	fake.AddImages("debian-cloud",
+		&compute.Image{Name: "debian-12-bookworm-v20240910", Family: "debian-12"},
+		&compute.Image{Name: "debian-12-bookworm-v20241009", Family: "debian-12"},
+		&compute.Image{Name: "debian-11-bullseye-v20241009", Family: "debian-11"},
+	)
+	fake.AddImages(testProject, &compute.Image{Name: "baked-20241001", Family: "baked"})
+
+	c.settings.InstanceTypes["debian"] = config.InstanceConfig{
+		Hardware:     "e2-small",
+		ImageProject: "debian-cloud",
+		ImageFamily:  "debian-12",
+	}
+	c.settings.InstanceTypes["pinned"] = config.InstanceConfig{
+		Extends: "debian",
+		Image:   "projects/debian-cloud/global/images/debian-12-bookworm-v20240910",
+	}
+	c.settings.InstanceTypes["pinnedbyname"] = config.InstanceConfig{
+		Hardware:     "e2-small",
+		ImageProject: "debian-cloud",
+		Image:        "debian-11-bullseye-v20241009",
+	}
+	c.settings.InstanceTypes["baked"] = config.InstanceConfig{
+		Hardware:    "e2-small",
+		ImageFamily: "baked",
+	}
+
+	for _, tc := range []struct {
+		config, want string
+	}{
+		{"small", "cos-cloud/global/images/cos-stable-105-17412-156-59"},
+		{"debian", "debian-cloud/global/images/debian-12-bookworm-v20241009"},
+		{"pinned", "debian-cloud/global/images/debian-12-bookworm-v20240910"},
+		{"pinnedbyname", "debian-cloud/global/images/debian-11-bullseye-v20241009"},
+		{"baked", testProject + "/global/images/baked-20241001"},
+	} {
+		if _, err := c.MakeNode(tc.config, tc.config+"-node"); err != nil {
+			t.Fatalf("MakeNode(%s): %v", tc.config, err)
+		}
+		inst := fake.Instance("us-east1-b", tc.config+"-node")
+		if got, want := inst.Disks[0].InitializeParams.SourceImage, fakecompute.LinkPrefix+tc.want; got != want {
+			t.Errorf("%s: SourceImage got %q, want %q", tc.config, got, want)
+		}
+	}
+
+	c.settings.InstanceTypes["missing"] = config.InstanceConfig{
+		Hardware:    "e2-small",
+		ImageFamily: "debian-13",
+		Extends:     "debian",
+	}
+	if _, err := c.MakeNode("missing", "missing-node"); err == nil {
+		t.Error("MakeNode with a missing image family should fail")
+	}
+}
+
+func TestParseCosName(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		channel string
+		version VersionTuple
+		bad     bool
+	}{
+		{name: "cos-stable-113-18244-85-49", channel: "stable", version: VersionTuple{113, 18244, 85, 49}},
+		{name: "cos-beta-117-18613-0-76", channel: "beta", version: VersionTuple{117, 18613, 0, 76}},
+		{name: "cos-dev-121-18716-0-0", channel: "dev", version: VersionTuple{121, 18716, 0, 0}},
+		{name: "cos-105-17412-448-12", channel: "lts", version: VersionTuple{105, 17412, 448, 12}},
+		{name: "cos-arm64-stable-113-18244-85-49", channel: "stable", version: VersionTuple{113, 18244, 85, 49}},
+		{name: "cos-arm64-109-17800-309-13", channel: "lts", version: VersionTuple{109, 17800, 309, 13}},
+		{name: "cos-109-17800-309-13-extra", bad: true},
+		{name: "cos-stable", bad: true},
+		{name: "cos", bad: true},
+		{name: "debian-12-bookworm-v20241009", bad: true},
+	} {
+		channel, version, err := parseCosName(tc.name)
+		if tc.bad {
+			if err == nil {
+				t.Errorf("parseCosName(%q) should fail", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCosName(%q): %v", tc.name, err)
+			continue
+		}
+		if channel != tc.channel || version != tc.version {
+			t.Errorf("parseCosName(%q) got %s %v, want %s %v", tc.name, channel, version, tc.channel, tc.version)
+		}
+	}
+}
+
+func TestVersionTupleCompare(t *testing.T) {
+	for _, tc := range []struct {
+		v, o VersionTuple
+		want int
+	}{
+		{VersionTuple{113, 18244, 85, 49}, VersionTuple{113, 18244, 85, 49}, 0},
+		{VersionTuple{113, 18244, 85, 49}, VersionTuple{109, 17800, 309, 13}, 1},
+		{VersionTuple{109, 17800, 309, 13}, VersionTuple{113, 18244, 85, 49}, -1},
+		// Later elements only matter when the earlier ones are equal.
+		{VersionTuple{105, 17412, 448, 12}, VersionTuple{105, 17412, 156, 59}, 1},
+		{VersionTuple{105, 17412, 156, 59}, VersionTuple{105, 17412, 156, 60}, -1},
+		{VersionTuple{101, 99999, 999, 99}, VersionTuple{105, 0, 0, 0}, -1},
+	} {
+		if got := tc.v.Compare(tc.o); got != tc.want {
+			t.Errorf("%v.Compare(%v) got %d, want %d", tc.v, tc.o, got, tc.want)
+		}
+	}
+}
+
+func TestNewestImage(t *testing.T) {
+	c, fake := newTestClient(t)
+	// cos-cloud lists its images in no useful order.
+	fake.AddImages("cos-cloud",
+		&compute.Image{Name: "cos-stable-109-17800-309-13", Family: "cos-stable"},
+		&compute.Image{Name: "cos-stable-113-18244-85-49", Family: "cos-stable"},
+		&compute.Image{Name: "cos-stable-113-18244-151-9", Family: "cos-stable"},
+		&compute.Image{Name: "cos-arm64-stable-117-18613-75-37", Family: "cos-arm64-stable"},
+		&compute.Image{Name: "cos-beta-117-18613-0-76", Family: "cos-beta"},
+		&compute.Image{Name: "cos-dev-121-18716-0-0", Family: "cos-dev"},
+		&compute.Image{Name: "cos-105-17412-448-12", Family: "cos-105-lts"},
+		&compute.Image{Name: "cos-109-17800-309-13", Family: "cos-109-lts"},
+		&compute.Image{Name: "cos-105-17412-535-17", Family: "cos-105-lts"},
+		&compute.Image{Name: "cos-113-18244-151-9", Family: "cos-113-lts", Deprecated: &compute.DeprecationStatus{State: "DEPRECATED"}},
+		&compute.Image{Name: "cos-arm64-113-18244-151-9", Family: "cos-arm64-113-lts"},
+		&compute.Image{Name: "cos-gpu-installer", Family: "cos-tools"},
+	)
+
+	for _, tc := range []struct {
+		channel, want string
+	}{
+		{"stable", "cos-stable-113-18244-151-9"},
+		{"beta", "cos-beta-117-18613-0-76"},
+		{"dev", "cos-dev-121-18716-0-0"},
+		{"lts", "cos-109-17800-309-13"},
+		{"lts-105", "cos-105-17412-535-17"},
+		{"lts-109", "cos-109-17800-309-13"},
+	} {
+		im, err := c.NewestImage("cos-cloud", tc.channel, "amd64")
+		if err != nil {
+			t.Errorf("NewestImage(%s): %v", tc.channel, err)
+			continue
+		}
+		if im.Name != tc.want {
+			t.Errorf("NewestImage(%s) got %s, want %s", tc.channel, im.Name, tc.want)
+		}
+	}
+
+	if _, err := c.NewestImage("cos-cloud", "lts-97", "amd64"); err == nil {
+		t.Error("NewestImage of a milestone without images should fail")
+	}
+
+	c.settings.InstanceTypes["lts"] = config.InstanceConfig{
+		Extends: "small",
+		Channel: "lts-105",
+	}
+	if _, err := c.MakeNode("lts", "longterm"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	if got, want := fake.Instance("us-east1-b", "longterm").Disks[0].InitializeParams.SourceImage, fakecompute.LinkPrefix+"cos-cloud/global/images/cos-105-17412-535-17"; got != want {
+		t.Errorf("SourceImage got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+package gcp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// preemptedOperation is the type of the operation that Compute Engine
+// records when it preempts an instance.
+const preemptedOperation = "compute.instances.preempted"
+
+// makeScheduling makes the scheduling options for a node with instance
+// configuration ic. It returns nil for the Compute Engine defaults.
+func makeScheduling(ic config.InstanceConfig) (*compute.Scheduling, error) {
+	if ic.Provisioning == "" && ic.TerminationAction == "" && ic.MaxRunDuration == "" && ic.AutomaticRestart == nil {
+		return nil, nil
+	}
+
+	sched := &compute.Scheduling{
+		AutomaticRestart:          ic.AutomaticRestart,
+		InstanceTerminationAction: strings.ToUpper(ic.TerminationAction),
+	}
+
+	switch ic.Provisioning {
+	case "", "standard":
+		sched.ProvisioningModel = "STANDARD"
+	case "spot":
+		sched.ProvisioningModel = "SPOT"
+	case "preemptible":
+		sched.Preemptible = true
+	default:
+		return nil, fmt.Errorf("unknown provisioning %q", ic.Provisioning)
+	}
+	if ic.Provisioning == "spot" || ic.Provisioning == "preemptible" {
+		// Compute Engine requires these for spot and preemptible nodes.
+		norestart := false
+		sched.AutomaticRestart = &norestart
+		sched.OnHostMaintenance = "TERMINATE"
+	}
+
+	if ic.MaxRunDuration != "" {
+		d, err := time.ParseDuration(ic.MaxRunDuration)
+		if err != nil {
+			return nil, fmt.Errorf("bad maxrunduration: %v", err)
+		}
+		sched.MaxRunDuration = &compute.Duration{Seconds: int64(d / time.Second)}
+	}
+	return sched, nil
+}
+
+// preemptions returns the time of the most recent preemption of each
+// instance in the Client's project that Compute Engine still has a
+// record of, indexed by the instance's self link.
+func (c *Client) preemptions() (map[string]time.Time, error) {
+	preempted := make(map[string]time.Time)
+	if err := c.service.GlobalOperations.AggregatedList(c.ProjectId).Filter(fmt.Sprintf("operationType = %q", preemptedOperation)).Pages(c.ctx, func(res *compute.OperationAggregatedList) error {
+		for _, sl := range res.Items {
+			for _, op := range sl.Operations {
+				t, err := time.Parse(time.RFC3339, op.InsertTime)
+				if err != nil {
+					continue
+				}
+				if t.After(preempted[op.TargetLink]) {
+					preempted[op.TargetLink] = t
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("can't list preemptions: %v", err)
+	}
+	return preempted, nil
+}
+
+// wasPreempted returns true if inst is stopped because Compute Engine
+// preempted it at one of the times in preempted.
+func wasPreempted(inst *compute.Instance, preempted map[string]time.Time) bool {
+	t, ok := preempted[inst.SelfLink]
+	if !ok || (inst.Status != "TERMINATED" && inst.Status != "STOPPING") {
+		return false
+	}
+	started, err := time.Parse(time.RFC3339, inst.LastStartTimestamp)
+	return err != nil || !t.Before(started)
+}
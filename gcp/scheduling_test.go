@@ -0,0 +1,110 @@
+package gcp
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestMakeScheduling(t *testing.T) {
+	yes, no := true, false
+	for _, tv := range []struct {
+		ic   config.InstanceConfig
+		want *compute.Scheduling
+	}{
+		{config.InstanceConfig{}, nil},
+		{
+			config.InstanceConfig{AutomaticRestart: &yes},
+			&compute.Scheduling{ProvisioningModel: "STANDARD", AutomaticRestart: &yes},
+		},
+		{
+			config.InstanceConfig{Provisioning: "spot", TerminationAction: "delete", MaxRunDuration: "4h"},
+			&compute.Scheduling{
+				ProvisioningModel:         "SPOT",
+				AutomaticRestart:          &no,
+				OnHostMaintenance:         "TERMINATE",
+				InstanceTerminationAction: "DELETE",
+				MaxRunDuration:            &compute.Duration{Seconds: 4 * 3600},
+			},
+		},
+		{
+			config.InstanceConfig{Provisioning: "preemptible"},
+			&compute.Scheduling{Preemptible: true, AutomaticRestart: &no, OnHostMaintenance: "TERMINATE"},
+		},
+		{
+			config.InstanceConfig{MaxRunDuration: "90m", TerminationAction: "stop"},
+			&compute.Scheduling{
+				ProvisioningModel:         "STANDARD",
+				InstanceTerminationAction: "STOP",
+				MaxRunDuration:            &compute.Duration{Seconds: 90 * 60},
+			},
+		},
+	} {
+		got, err := makeScheduling(tv.ic)
+		if err != nil {
+			t.Fatalf("makeScheduling(%+v): %v", tv.ic, err)
+		}
+		if diff := cmp.Diff(tv.want, got); diff != "" {
+			t.Errorf("makeScheduling(%+v) mismatch (-want +got):\n%s", tv.ic, diff)
+		}
+	}
+}
+
+func TestMakeNodeSpot(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["cheap"] = config.InstanceConfig{
+		Extends:           "small",
+		Provisioning:      "spot",
+		TerminationAction: "stop",
+	}
+
+	if _, err := c.MakeNode("cheap", "builder"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	sched := fake.Instance("us-east1-b", "builder").Scheduling
+	if sched == nil || sched.ProvisioningModel != "SPOT" || sched.InstanceTerminationAction != "STOP" {
+		t.Errorf("MakeNode made scheduling %+v, want a spot node that stops", sched)
+	}
+}
+
+func TestNodesPreempted(t *testing.T) {
+	c, fake := newTestClient(t)
+	token := "spot-token"
+	fake.AddInstance("us-east1-b", &compute.Instance{
+		Name: "spot",
+		Metadata: &compute.Metadata{
+			Items: []*compute.MetadataItems{{Key: "instancetoken", Value: &token}},
+		},
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{AccessConfigs: []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}},
+		},
+	})
+	fake.AddInstance("us-east1-b", &compute.Instance{Name: "steady"})
+	fake.Preempt("us-east1-b", "spot")
+
+	preempted := func() map[string]bool {
+		nodes, err := c.Nodes(nil)
+		if err != nil {
+			t.Fatalf("Nodes: %v", err)
+		}
+		got := make(map[string]bool)
+		for _, n := range nodes {
+			got[n.Name] = n.Preempted
+		}
+		return got
+	}
+
+	if diff := cmp.Diff(map[string]bool{"spot": true, "steady": false}, preempted()); diff != "" {
+		t.Errorf("preempted mismatch (-want +got):\n%s", diff)
+	}
+
+	// A restarted node is no longer preempted.
+	if _, err := c.Start("", "spot"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if diff := cmp.Diff(map[string]bool{"spot": false, "steady": false}, preempted()); diff != "" {
+		t.Errorf("preempted after Start mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,138 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+	iam "google.golang.org/api/iam/v1"
+)
+
+// scopePrefix is the prefix of the OAuth scope URLs.
+const scopePrefix = "https://www.googleapis.com/auth/"
+
+// defaultScopes are the scopes that Compute Engine gives a node's
+// service account by default. They let the node read from Cloud Storage
+// and report logs and metrics but not administer the project. A
+// configuration whose nodes run sessionender, which deletes the node,
+// must add compute in its scopes.
+var defaultScopes = []string{
+	scopePrefix + "devstorage.read_only",
+	scopePrefix + "logging.write",
+	scopePrefix + "monitoring.write",
+	scopePrefix + "service.management.readonly",
+	scopePrefix + "servicecontrol",
+	scopePrefix + "trace.append",
+}
+
+// actAsPermission is the permission needed to make a node that runs as
+// a service account.
+const actAsPermission = "iam.serviceAccounts.actAs"
+
+// makeServiceAccounts makes the service accounts of a node with
+// configuration ic.
+func makeServiceAccounts(ic config.InstanceConfig) []*compute.ServiceAccount {
+	email := ic.ServiceAccount
+	switch email {
+	case "none":
+		return nil
+	case "":
+		email = "default"
+	}
+
+	scopes := defaultScopes
+	if ic.Scopes != nil {
+		scopes = make([]string, 0, len(ic.Scopes))
+		for _, s := range ic.Scopes {
+			if !strings.HasPrefix(s, "https://") {
+				s = scopePrefix + s
+			}
+			scopes = append(scopes, s)
+		}
+	}
+	return []*compute.ServiceAccount{{Email: email, Scopes: scopes}}
+}
+
+// CheckServiceAccounts checks that the service account of each instance
+// configuration in the Client's settings exists and that the caller can
+// make nodes that run as it. It returns the Problems found.
+func (c *Client) CheckServiceAccounts() (config.Problems, error) {
+	names := make([]string, 0, len(c.settings.InstanceTypes))
+	for name := range c.settings.InstanceTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems config.Problems
+	checked := make(map[string]string)
+	defaultaccount := ""
+	for _, name := range names {
+		ic, err := c.settings.Instance(name)
+		if err != nil {
+			return nil, err
+		}
+		email := ic.ServiceAccount
+		switch email {
+		case "none":
+			continue
+		case "", "default":
+			if defaultaccount == "" {
+				project, err := c.service.Projects.Get(c.ProjectId).Context(c.ctx).Do()
+				if err != nil {
+					return nil, fmt.Errorf("can't find the default service account: %v", err)
+				}
+				defaultaccount = project.DefaultServiceAccount
+			}
+			email = defaultaccount
+		}
+
+		problem, ok := checked[email]
+		if !ok {
+			if problem, err = c.checkServiceAccount(email); err != nil {
+				return nil, err
+			}
+			checked[email] = problem
+		}
+		if problem != "" {
+			problems = append(problems, c.settings.Problemf("instance."+name+".serviceaccount", "instance %s: %s", name, problem))
+		}
+	}
+	return problems, nil
+}
+
+// checkServiceAccount returns a description of why the caller can't make
+// nodes that run as the service account email or "" if it can.
+func (c *Client) checkServiceAccount(email string) (string, error) {
+	resource := "projects/-/serviceAccounts/" + email
+	if _, err := c.iam.Projects.ServiceAccounts.Get(resource).Context(c.ctx).Do(); isNotFound(err) {
+		return fmt.Sprintf("service account %s doesn't exist", email), nil
+	} else if err != nil {
+		return "", fmt.Errorf("can't get service account %s: %v", email, err)
+	}
+
+	res, err := c.iam.Projects.ServiceAccounts.TestIamPermissions(resource, &iam.TestIamPermissionsRequest{
+		Permissions: []string{actAsPermission},
+	}).Context(c.ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("can't check permissions on service account %s: %v", email, err)
+	}
+	for _, p := range res.Permissions {
+		if p == actAsPermission {
+			return "", nil
+		}
+	}
+	return fmt.Sprintf("you can't act as service account %s: you need %s", email, actAsPermission), nil
+}
+
+// CheckServiceAccounts checks the service accounts named in settings.
+// See Client.CheckServiceAccounts.
+func CheckServiceAccounts(settings *config.Settings) (config.Problems, error) {
+	c, err := NewClient(context.Background(), settings)
+	if err != nil {
+		return nil, err
+	}
+	return c.CheckServiceAccounts()
+}
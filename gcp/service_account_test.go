@@ -0,0 +1,89 @@
+package gcp
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestMakeNodeServiceAccount(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["builder"] = config.InstanceConfig{
+		Extends:        "small",
+		ServiceAccount: "builder@testproject.iam.gserviceaccount.com",
+		Scopes:         []string{"devstorage.read_write", "https://www.googleapis.com/auth/logging.write"},
+	}
+	c.settings.InstanceTypes["isolated"] = config.InstanceConfig{
+		Extends:        "small",
+		ServiceAccount: "none",
+	}
+
+	for _, tc := range []struct {
+		config string
+		want   []*compute.ServiceAccount
+	}{
+		{"small", []*compute.ServiceAccount{{Email: "default", Scopes: defaultScopes}}},
+		{"builder", []*compute.ServiceAccount{{
+			Email: "builder@testproject.iam.gserviceaccount.com",
+			Scopes: []string{
+				"https://www.googleapis.com/auth/devstorage.read_write",
+				"https://www.googleapis.com/auth/logging.write",
+			},
+		}}},
+		{"isolated", nil},
+	} {
+		if _, err := c.MakeNode(tc.config, tc.config+"-node"); err != nil {
+			t.Fatalf("MakeNode(%s): %v", tc.config, err)
+		}
+		inst := fake.Instance("us-east1-b", tc.config+"-node")
+		if diff := cmp.Diff(tc.want, inst.ServiceAccounts); diff != "" {
+			t.Errorf("%s: service accounts mismatch (-want +got):\n%s", tc.config, diff)
+		}
+	}
+
+	for _, s := range defaultScopes {
+		if s == compute.CloudPlatformScope || s == compute.ComputeScope {
+			t.Errorf("defaultScopes includes %s", s)
+		}
+	}
+}
+
+func TestCheckServiceAccounts(t *testing.T) {
+	c, fake := newTestClient(t)
+	fake.AddServiceAccount("builder@testproject.iam.gserviceaccount.com", true)
+	fake.AddServiceAccount("admin@testproject.iam.gserviceaccount.com", false)
+	c.settings.InstanceTypes["builder"] = config.InstanceConfig{
+		Extends:        "small",
+		ServiceAccount: "builder@testproject.iam.gserviceaccount.com",
+	}
+	c.settings.InstanceTypes["admin"] = config.InstanceConfig{
+		Extends:        "small",
+		ServiceAccount: "admin@testproject.iam.gserviceaccount.com",
+	}
+	c.settings.InstanceTypes["ghost"] = config.InstanceConfig{
+		Extends:        "small",
+		ServiceAccount: "ghost@testproject.iam.gserviceaccount.com",
+	}
+	c.settings.InstanceTypes["isolated"] = config.InstanceConfig{
+		Extends:        "small",
+		ServiceAccount: "none",
+	}
+
+	problems, err := c.CheckServiceAccounts()
+	if err != nil {
+		t.Fatalf("CheckServiceAccounts: %v", err)
+	}
+	got := make([]string, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, p.Message)
+	}
+	want := []string{
+		"instance admin: you can't act as service account admin@testproject.iam.gserviceaccount.com: you need iam.serviceAccounts.actAs",
+		"instance ghost: service account ghost@testproject.iam.gserviceaccount.com doesn't exist",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -20,27 +20,26 @@ import (
 
 const metabase = "http://metadata.google.internal/computeMetadata/v1/instance/attributes/"
 
-// TODO(rjk): This needs tests that can run locally. For that, I'd need a
-// mock ssh server and a mock metadata service? (Yes?)
-
 // ConfigureViaSsh invokes the specified command string via ssh to
 // perform additional configuration of the target node. Significant
 // additional featurism is possible.
 func ConfigureViaSsh(settings *config.Settings, ni *NodeInfo, client *ssh.Client) error {
-	// I have no way of knowing the hostKey because I didn't set it. The
-	// system is newly launched and it makes the key for itself. But: I could
-	// make a bespoke key. Then, the "public" key would also be private. Or I
-	// could set some other kind of key and read it back.
-	//
-	// I want to preserve the key and use it when reconnecting. I need to
-	// verify that the node is who I think it is. I can set a secret _on_ the
-	// node at creation (shortly before) and then discover if if it has the
-	// secret?
-	//
-	// Given that the IP address comes over a secure connection, the only way
-	// that an adversary could man-in-the-middle me is if a router between me and
-	// Google has been misconfigured and can forward traffic to an arbitrary
-	// third party. I must validate some kind of shared secret.
+	if err := CheckToken(ni, client); err != nil {
+		return err
+	}
+	return InstallViaSsh(settings, ni, client)
+}
+
+// CheckToken verifies that client is connected to the node described by
+// ni by reading the node's instancetoken from its metadata service.
+func CheckToken(ni *NodeInfo, client *ssh.Client) error {
+	// The connection has verified the host key that gocloud delivered
+	// to the node in its metadata. But nodes made before gocloud
+	// delivered host keys accept any host key. Given that the IP address
+	// comes over a secure connection, the only way that an adversary
+	// could man-in-the-middle me is if a router between me and Google has
+	// been misconfigured and can forward traffic to an arbitrary third
+	// party. The shared secret from the metadata rules that out.
 	pnm, err := config.GetNodeMetadata(
 		config.NewNodeProxiedMetadataClient(NewSshProxiedTransport(client)))
 	if err != nil {
@@ -51,8 +50,7 @@ func ConfigureViaSsh(settings *config.Settings, ni *NodeInfo, client *ssh.Client
 	if gottoken != ni.Token {
 		return fmt.Errorf("Got token %q, want %q. Maybe this is an IP hijack?", gottoken, ni.Token)
 	}
-
-	return InstallViaSsh(settings, ni, client)
+	return nil
 }
 
 func NewSshProxiedTransport(client *ssh.Client) http.RoundTripper {
@@ -81,9 +79,9 @@ func InstallViaSsh(settings *config.Settings, ni *NodeInfo, client *ssh.Client)
 	// tar up the scripts and binaries locally.
 	go func() {
 		defer inpipe.Close()
-		if err := TarGZTools(inpipe); err != nil {
+		if err := TarGZTools(inpipe, ni.Arch); err != nil {
 			// TODO(rjk): I think that I can do something better about exiting.
-			log.Println("can't tar: %v", err)
+			log.Printf("can't tar: %v", err)
 		}
 	}()
 
@@ -115,27 +113,40 @@ type Paths struct {
 	From    string
 	To      string
 	Pattern []string
+
+	// PerArch says that From holds a directory of binaries for each
+	// architecture, named amd64 and arm64 like GOARCH.
+	PerArch bool
+}
+
+// toolPaths lists the local files that TarGZTools packs up for the node.
+// TODO(rjk): This configuration should come from the TOML file.
+var toolPaths = []Paths{
+	{
+		From:    "/usr/local/script",
+		To:      "/usr/local/script",
+		Pattern: []string{"*"},
+	},
+	{
+		From:    "/Users/rjkroege/wrks/archive/bins/linux",
+		To:      "/usr/local/bin",
+		Pattern: []string{"cpud", "eza", "gotop", "rc", "sessionender", "mk", "p", "sam"},
+		PerArch: true,
+	},
 }
 
-func TarGZTools(w io.Writer) error {
+// TarGZTools writes a gzipped tar file of the tools for a node with
+// architecture arch, amd64 or arm64, to w.
+func TarGZTools(w io.Writer, arch string) error {
 	zfd := gzip.NewWriter(w)
 	defer zfd.Close()
 	tw := tar.NewWriter(zfd)
 	defer tw.Close()
 
-	// TODO(rjk): This configuration should come from the TOML file.
-	for _, ptho := range []Paths{
-		{
-			From:    "/usr/local/script",
-			To:      "/usr/local/script",
-			Pattern: []string{"*"},
-		},
-		{
-			From:    "/Users/rjkroege/wrks/archive/bins/linux/amd64",
-			To:      "/usr/local/bin",
-			Pattern: []string{"cpud", "eza", "gotop",  "rc", "sessionender", "mk", "p", "sam"},
-		},
-	} {
+	for _, ptho := range toolPaths {
+		if ptho.PerArch {
+			ptho.From = filepath.Join(ptho.From, arch)
+		}
 		dfs := os.DirFS(ptho.From)
 		files := make([]string, 0, 20)
 		for _, g := range ptho.Pattern {
@@ -0,0 +1,191 @@
+package gcp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/gocloud/config"
+	"github.com/rjkroege/gocloud/gcp/sshtest"
+)
+
+// newTestSshServer starts an sshtest.Server serving a node with token
+// and returns it along with settings and a NodeInfo to reach it.
+func newTestSshServer(t *testing.T, token string) (*sshtest.Server, *config.Settings, *NodeInfo) {
+	t.Helper()
+	keydir := t.TempDir()
+	pub, err := sshtest.WriteKeyPair(keydir)
+	if err != nil {
+		t.Fatalf("WriteKeyPair: %v", err)
+	}
+
+	srv, err := sshtest.NewServer(t.TempDir(), pub)
+	if err != nil {
+		t.Fatalf("sshtest.NewServer: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	srv.SetMetadata(sshtest.StaticMetadata(map[string]string{
+		"username":      "tester",
+		"sshkey":        "ssh-ed25519 AAAAtest",
+		"rcloneconfig":  "",
+		"instancetoken": token,
+		"githost":       "https://git.example.com/scripts.git",
+	}))
+
+	settings := &config.Settings{
+		SshPrivateKeyFile: filepath.Join(keydir, "id_test"),
+		SshPublicKeyFile:  filepath.Join(keydir, "id_test.pub"),
+	}
+	ni := &NodeInfo{
+		Name:    "testnode",
+		Addr:    "127.0.0.1",
+		Token:   "secret-token",
+		Port:    srv.Port(),
+		HostKey: srv.HostKey().PublicKey(),
+	}
+	return srv, settings, ni
+}
+
+// setToolPaths makes TarGZTools pack up a single test binary for the
+// duration of the test.
+func setToolPaths(t *testing.T) {
+	t.Helper()
+	bindir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bindir, "sessionender"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("can't write test binary: %v", err)
+	}
+
+	saved := toolPaths
+	toolPaths = []Paths{
+		{
+			From:    bindir,
+			To:      "/usr/local/bin",
+			Pattern: []string{"sessionender"},
+		},
+	}
+	t.Cleanup(func() { toolPaths = saved })
+}
+
+func TestConfigureViaSsh(t *testing.T) {
+	setToolPaths(t)
+	srv, settings, ni := newTestSshServer(t, "secret-token")
+
+	client, err := WaitForSsh(settings, ni)
+	if err != nil {
+		t.Fatalf("WaitForSsh: %v", err)
+	}
+	defer client.Close()
+
+	if err := ConfigureViaSsh(settings, ni, client); err != nil {
+		t.Fatalf("ConfigureViaSsh: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{
+		"cd / ; tar xzf -",
+		"sudo /usr/local/bin/sessionender",
+		"sudo /usr/local/bin/cpud -pk /usr/local/keys/pk",
+	}, srv.Commands()); diff != "" {
+		t.Errorf("commands mismatch (-want +got):\n%s", diff)
+	}
+
+	fi, err := os.Stat(filepath.Join(srv.Dir, "usr", "local", "bin", "sessionender"))
+	if err != nil {
+		t.Fatalf("tools weren't extracted: %v", err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0755); got != want {
+		t.Errorf("extracted mode got %v, want %v", got, want)
+	}
+	if len(srv.TarStream()) == 0 {
+		t.Error("no tar stream was sent")
+	}
+}
+
+func TestTarGZToolsPerArch(t *testing.T) {
+	bindir := t.TempDir()
+	for _, arch := range []string{"amd64", "arm64"} {
+		if err := os.MkdirAll(filepath.Join(bindir, arch), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(bindir, arch, "sessionender"), []byte(arch), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := toolPaths
+	toolPaths = []Paths{
+		{
+			From:    bindir,
+			To:      "/usr/local/bin",
+			Pattern: []string{"sessionender"},
+			PerArch: true,
+		},
+	}
+	t.Cleanup(func() { toolPaths = saved })
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		var buf bytes.Buffer
+		if err := TarGZTools(&buf, arch); err != nil {
+			t.Fatalf("TarGZTools(%s): %v", arch, err)
+		}
+		zr, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(zr)
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("TarGZTools(%s) wrote no files: %v", arch, err)
+		}
+		if got, want := hdr.Name, "/usr/local/bin/sessionender"; got != want {
+			t.Errorf("TarGZTools(%s) file got %s, want %s", arch, got, want)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(contents); got != arch {
+			t.Errorf("TarGZTools(%s) packed the %s binary", arch, got)
+		}
+	}
+}
+
+func TestConfigureViaSshWrongToken(t *testing.T) {
+	setToolPaths(t)
+	srv, settings, ni := newTestSshServer(t, "hijacker-token")
+
+	client, err := WaitForSsh(settings, ni)
+	if err != nil {
+		t.Fatalf("WaitForSsh: %v", err)
+	}
+	defer client.Close()
+
+	if err := ConfigureViaSsh(settings, ni, client); err == nil {
+		t.Error("ConfigureViaSsh should fail when the token doesn't match")
+	}
+	if got := srv.Commands(); len(got) != 0 {
+		t.Errorf("ConfigureViaSsh ran %v on a node that failed the token check", got)
+	}
+}
+
+func TestWrongHostKey(t *testing.T) {
+	_, settings, ni := newTestSshServer(t, "secret-token")
+
+	other, err := sshtest.NewServer(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("sshtest.NewServer: %v", err)
+	}
+	other.Close()
+
+	sshconf, err := MakeSshClientConfig(settings, other.HostKey().PublicKey())
+	if err != nil {
+		t.Fatalf("MakeSshClientConfig: %v", err)
+	}
+	if client, err := connectToSsh(sshconf, nil, ni.Ssh()); err == nil {
+		client.Close()
+		t.Error("connecting to a node with the wrong host key should fail")
+	}
+}
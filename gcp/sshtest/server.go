@@ -0,0 +1,434 @@
+// Package sshtest provides an in-process ssh server for testing the ssh
+// driven configuration of new nodes. The server runs on the loopback
+// interface. It carries out exec requests against a sandbox directory
+// and forwards direct-tcpip channels for the metadata service to a fake
+// metadata server. It can also stand in for a jump host.
+package sshtest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// MetadataHost is the host name of the metadata service. The server
+// forwards direct-tcpip channels to it to the fake metadata server.
+const MetadataHost = "metadata.google.internal"
+
+// HostKeyMetadata is the metadata attribute holding the node's private
+// host key.
+const HostKeyMetadata = "sshhostkey"
+
+// Server is an ssh server for tests.
+type Server struct {
+	// Addr is the host:port address of the server.
+	Addr string
+
+	// Dir is the sandbox directory. It stands in for the root of the
+	// node's file system.
+	Dir string
+
+	hostkey    ssh.Signer
+	authorized ssh.PublicKey
+	listener   net.Listener
+	metaserver *httptest.Server
+
+	// mu guards the fields below. Connections run on their own goroutines
+	// so tests change the server's behaviour through setters.
+	mu        sync.Mutex
+	metadata  func(key string) (string, bool)
+	exec      func(cmd string, stdin io.Reader, stdout, stderr io.Writer) int
+	forward   func(addr string) (string, bool)
+	commands  []string
+	tarstream []byte
+}
+
+// NewServer starts an ssh server that accepts connections authenticated
+// with authorized and runs commands against the sandbox dir. Call Close
+// when done.
+func NewServer(dir string, authorized ssh.PublicKey) (*Server, error) {
+	_, hostkey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't make host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostkey)
+	if err != nil {
+		return nil, fmt.Errorf("can't make host key signer: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("can't listen: %v", err)
+	}
+
+	s := &Server{
+		Addr:       listener.Addr().String(),
+		Dir:        dir,
+		hostkey:    signer,
+		authorized: authorized,
+		listener:   listener,
+	}
+	s.metaserver = httptest.NewServer(http.HandlerFunc(s.serveMetadata))
+
+	go s.accept()
+	return s, nil
+}
+
+// HostKey returns the server's own host key. Like a node whose boot
+// configuration installs the host key delivered in its metadata, the
+// server uses the key in the sshhostkey metadata attribute instead when
+// there is one.
+func (s *Server) HostKey() ssh.Signer {
+	return s.hostkey
+}
+
+// SetMetadata makes f supply the metadata attributes: f returns the value
+// of the attribute key. When f is nil, the fake metadata server has no
+// attributes.
+func (s *Server) SetMetadata(f func(key string) (string, bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metadata = f
+}
+
+// Metadata returns the value of the metadata attribute key.
+func (s *Server) Metadata(key string) (string, bool) {
+	s.mu.Lock()
+	f := s.metadata
+	s.mu.Unlock()
+	if f == nil {
+		return "", false
+	}
+	return f(key)
+}
+
+// SetExec makes f run commands other than the tar extraction: f returns
+// their exit status. By default, such commands are recorded and succeed
+// without doing anything.
+func (s *Server) SetExec(f func(cmd string, stdin io.Reader, stdout, stderr io.Writer) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exec = f
+}
+
+// SetForward lets the server act as a jump host. f returns the address
+// to connect a direct-tcpip channel for the host:port address addr to
+// or false if addr is unreachable.
+func (s *Server) SetForward(f func(addr string) (string, bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forward = f
+}
+
+// connHostKey returns the host key for a new connection.
+func (s *Server) connHostKey() ssh.Signer {
+	if pem, ok := s.Metadata(HostKeyMetadata); ok {
+		if signer, err := ssh.ParsePrivateKey([]byte(pem)); err == nil {
+			return signer
+		}
+		log.Printf("sshtest: ignoring bad %s metadata", HostKeyMetadata)
+	}
+	return s.hostkey
+}
+
+// Port returns the port that the server listens on.
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.metaserver.Close()
+}
+
+// Commands returns every command that clients have run or started, in
+// order of arrival.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// TarStream returns the most recent (compressed) tar stream written to a
+// tar extraction command.
+func (s *Server) TarStream() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tarstream
+}
+
+// StaticMetadata makes a metadata function for SetMetadata that serves the contents of
+// meta.
+func StaticMetadata(meta map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := meta[key]
+		return v, ok
+	}
+}
+
+// WriteKeyPair writes a new private key and its public key in
+// authorized_keys format to dir as id_test and id_test.pub and returns
+// the public key.
+func WriteKeyPair(dir string) (ssh.PublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't make key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal key: %v", err)
+	}
+	sshpub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("can't make ssh public key: %v", err)
+	}
+
+	privpem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "id_test"), privpem, 0600); err != nil {
+		return nil, fmt.Errorf("can't write private key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "id_test.pub"), ssh.MarshalAuthorizedKey(sshpub), 0600); err != nil {
+		return nil, fmt.Errorf("can't write public key: %v", err)
+	}
+	return sshpub, nil
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), s.authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unauthorized key")
+		},
+	}
+	config.AddHostKey(s.connHostKey())
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+			go s.serveSession(nc)
+		case "direct-tcpip":
+			go s.serveDirect(nc)
+		default:
+			nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+func (s *Server) serveSession(nc ssh.NewChannel) {
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			req.Reply(true, nil)
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+
+			// Record the command before replying so that a command
+			// started by the client is visible as soon as Start returns.
+			s.mu.Lock()
+			s.commands = append(s.commands, payload.Command)
+			s.mu.Unlock()
+			req.Reply(true, nil)
+
+			status := s.run(payload.Command, ch, ch, ch.Stderr())
+			ch.CloseWrite()
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// run carries out cmd against the sandbox.
+func (s *Server) run(cmd string, stdin io.Reader, stdout, stderr io.Writer) int {
+	dir := "/"
+	command := strings.TrimSpace(cmd)
+	if strings.HasPrefix(command, "cd ") {
+		if i := strings.IndexAny(command, ";&"); i > 0 {
+			dir = strings.TrimSpace(command[len("cd "):i])
+			command = strings.TrimLeft(command[i:], ";& ")
+		}
+	}
+
+	if f := strings.Fields(command); len(f) == 3 && f[0] == "tar" && strings.HasPrefix(f[1], "x") && f[2] == "-" {
+		if err := s.untar(dir, stdin); err != nil {
+			fmt.Fprintf(stderr, "tar: %v\n", err)
+			return 2
+		}
+		return 0
+	}
+
+	s.mu.Lock()
+	exec := s.exec
+	s.mu.Unlock()
+	if exec != nil {
+		return exec(cmd, stdin, stdout, stderr)
+	}
+	return 0
+}
+
+// untar saves and extracts the gzipped tar stream from r into dir
+// inside of the sandbox.
+func (s *Server) untar(dir string, r io.Reader) error {
+	stream, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("can't read stream: %v", err)
+	}
+	s.mu.Lock()
+	s.tarstream = stream
+	s.mu.Unlock()
+
+	zr, err := gzip.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		return fmt.Errorf("not a gzip stream: %v", err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("bad tar stream: %v", err)
+		}
+
+		// Joining to a rooted path keeps every entry inside of the sandbox.
+		path := filepath.Join(s.Dir, filepath.Join("/", dir, hdr.Name))
+		if hdr.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fd, tr); err != nil {
+			fd.Close()
+			return err
+		}
+		if err := fd.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) serveDirect(nc ssh.NewChannel) {
+	var payload struct {
+		Host           string
+		Port           uint32
+		OriginatorIP   string
+		OriginatorPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &payload); err != nil {
+		nc.Reject(ssh.ConnectionFailed, "bad payload")
+		return
+	}
+	target := s.metaserver.Listener.Addr().String()
+	if payload.Host != MetadataHost {
+		addr := net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port))
+		s.mu.Lock()
+		f := s.forward
+		s.mu.Unlock()
+		forward, ok := "", false
+		if f != nil {
+			forward, ok = f(addr)
+		}
+		if !ok {
+			nc.Reject(ssh.Prohibited, "only the metadata service is reachable")
+			return
+		}
+		target = forward
+	}
+
+	conn, err := net.Dial("tcp", target)
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		io.Copy(conn, ch)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(ch, conn)
+	ch.Close()
+	conn.Close()
+}
+
+func (s *Server) serveMetadata(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/computeMetadata/v1/instance/attributes/"
+	if r.Header.Get("Metadata-Flavor") != "Google" {
+		http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	v, ok := s.Metadata(strings.TrimPrefix(r.URL.Path, prefix))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := io.WriteString(w, v); err != nil {
+		log.Printf("sshtest: can't write metadata: %v", err)
+	}
+}
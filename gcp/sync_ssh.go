@@ -0,0 +1,86 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rjkroege/gocloud/config"
+)
+
+// SshAliases returns the ssh aliases of the running nodes that gocloud
+// made in every zone. keep holds the names of the other instances, whose
+// ssh aliases, if any, shouldn't be removed.
+func (c *Client) SshAliases() (aliases []config.SshAlias, keep []string, err error) {
+	instances, err := c.List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]bool, len(instances))
+	for _, inst := range instances {
+		if seen[inst.Name] {
+			// An ambiguous name has one alias. Keep the first.
+			continue
+		}
+		seen[inst.Name] = true
+		if inst.Status != "RUNNING" {
+			keep = append(keep, inst.Name)
+			continue
+		}
+		ni, err := c.nodeInfo(inst)
+		var notoken *noTokenError
+		if errors.As(err, &notoken) {
+			// Not made by gocloud.
+			keep = append(keep, inst.Name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		aliases = append(aliases, config.SshAlias{
+			Project:  c.ProjectId,
+			Name:     ni.Name,
+			Addr:     ni.Addr,
+			JumpHost: ni.JumpHost,
+			HostKey:  ni.HostKey,
+		})
+	}
+	return aliases, keep, nil
+}
+
+// SyncSsh brings the ssh aliases in ~/.ssh/config up to date with the
+// nodes of the project in every zone: it adds missing aliases, updates
+// changed ones and removes those of the project's deleted nodes. It
+// writes each change to w as a diff. With dryRun, it changes nothing.
+func SyncSsh(settings *config.Settings, w io.Writer, dryRun bool) error {
+	c, err := NewClient(context.Background(), settings)
+	if err != nil {
+		return err
+	}
+
+	aliases, keep, err := c.SshAliases()
+	if err != nil {
+		return err
+	}
+	changes, err := config.PlanSshAliases(c.ProjectId, aliases, keep)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "~/.ssh/config is up to date")
+		return nil
+	}
+
+	for _, ch := range changes {
+		ch.Diff(w)
+		if dryRun {
+			continue
+		}
+		if err := ch.Apply(); err != nil {
+			return fmt.Errorf("can't update the ssh alias of %s: %v", ch.Alias.Name, err)
+		}
+	}
+	return nil
+}
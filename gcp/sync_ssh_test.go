@@ -0,0 +1,44 @@
+package gcp
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestSshAliases(t *testing.T) {
+	c, fake := newTestClient(t)
+	running, err := c.MakeNode("small", "running")
+	if err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	if _, err := c.MakeNode("small", "stopped"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+	if err := c.Stop("", "stopped"); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	fake.AddInstance("us-west1-a", &compute.Instance{Name: "foreign"})
+
+	aliases, keep, err := c.SshAliases()
+	if err != nil {
+		t.Fatalf("SshAliases: %v", err)
+	}
+	if len(aliases) != 1 || aliases[0].Name != "running" || aliases[0].Addr != running.Addr || aliases[0].HostKey == nil {
+		t.Errorf("SshAliases got aliases %+v, want running at %s with a pinned key", aliases, running.Addr)
+	}
+	if diff := cmp.Diff([]string{"foreign", "stopped"}, keep); diff != "" {
+		t.Errorf("SshAliases keep mismatch (-want +got):\n%s", diff)
+	}
+
+	// A running gocloud node that can't be reached isn't foreign.
+	token := "secret"
+	fake.AddInstance("us-west1-a", &compute.Instance{
+		Name:     "unreachable",
+		Metadata: &compute.Metadata{Items: []*compute.MetadataItems{{Key: "instancetoken", Value: &token}}},
+	})
+	if _, _, err := c.SshAliases(); err == nil {
+		t.Error("SshAliases should fail for a gocloud node without an address")
+	}
+}
@@ -0,0 +1,80 @@
+package gcp
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// OperationError is the failure of a Compute Engine operation, e.g. an
+// insert that exceeded a quota.
+type OperationError struct {
+	// Operation is the operation type, e.g. insert.
+	Operation string
+
+	// Target is the name of the resource that the operation changed.
+	Target string
+
+	Errors []*compute.OperationErrorErrors
+}
+
+func (e *OperationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, oe := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s (%s)", oe.Message, oe.Code))
+	}
+	return fmt.Sprintf("%s of %s failed: %s", e.Operation, e.Target, strings.Join(msgs, "; "))
+}
+
+// waitForOperation waits for the zonal or regional operation op to
+// finish, reporting its progress and warnings to c.Progress. It gives up
+// when c's context is done. It returns the operation's errors, if any,
+// as an *OperationError.
+func (c *Client) waitForOperation(op *compute.Operation) error {
+	where := path.Base(op.Zone)
+	wait := func() (*compute.Operation, error) {
+		return c.service.ZoneOperations.Wait(c.ProjectId, where, op.Name).Context(c.ctx).Do()
+	}
+	if op.Zone == "" {
+		where = path.Base(op.Region)
+		wait = func() (*compute.Operation, error) {
+			return c.service.RegionOperations.Wait(c.ProjectId, where, op.Name).Context(c.ctx).Do()
+		}
+	}
+	target := path.Base(op.TargetLink)
+	for op.Status != "DONE" {
+		c.progressf("%s of %s in %s: %s %d%%\n", op.OperationType, target, where, op.Status, op.Progress)
+		// Wait returns when the operation is done or after about two
+		// minutes, whichever comes first.
+		next, err := wait()
+		if cerr := c.ctx.Err(); cerr != nil {
+			return fmt.Errorf("gave up waiting for %s of %s: %v", op.OperationType, target, cerr)
+		}
+		if err != nil {
+			return fmt.Errorf("can't wait for %s of %s: %v", op.OperationType, target, err)
+		}
+		op = next
+	}
+
+	for _, w := range op.Warnings {
+		c.progressf("%s of %s in %s: warning: %s (%s)\n", op.OperationType, target, where, w.Message, w.Code)
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return &OperationError{
+			Operation: op.OperationType,
+			Target:    target,
+			Errors:    op.Error.Errors,
+		}
+	}
+	c.progressf("%s of %s in %s: done\n", op.OperationType, target, where)
+	return nil
+}
+
+// progressf prints a progress message to c.Progress if it's set.
+func (c *Client) progressf(format string, args ...interface{}) {
+	if c.Progress != nil {
+		fmt.Fprintf(c.Progress, format, args...)
+	}
+}
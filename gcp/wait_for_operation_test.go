@@ -0,0 +1,138 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rjkroege/gocloud/config"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestMakeNodeQuota(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["server"] = config.InstanceConfig{
+		Extends:    "small",
+		ExternalIp: "static",
+	}
+	quota := "Quota 'CPUS' exceeded.  Limit: 24.0 in region us-east1."
+	fake.FailInsert("QUOTA_EXCEEDED", quota)
+
+	_, err := c.MakeNode("server", "greedy")
+	var operr *OperationError
+	if !errors.As(err, &operr) {
+		t.Fatalf("MakeNode got error %v, want an OperationError", err)
+	}
+	if got, want := operr.Operation, "insert"; got != want {
+		t.Errorf("OperationError.Operation got %q, want %q", got, want)
+	}
+	if !strings.Contains(err.Error(), quota) || !strings.Contains(err.Error(), "QUOTA_EXCEEDED") {
+		t.Errorf("MakeNode error %q doesn't include the quota failure", err)
+	}
+	if fake.Instance("us-east1-b", "greedy") != nil {
+		t.Error("the failed insert made an instance")
+	}
+	if fake.Address("us-east1", "greedy-ip") != nil {
+		t.Error("MakeNode didn't release the static address after the insert failed")
+	}
+}
+
+func TestMakeNodeInterrupted(t *testing.T) {
+	c, fake := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	fake.Stall = true
+
+	// The interrupted insert made the node anyway so it's not a
+	// NotMadeError and cleaning up deletes the node.
+	_, err := c.WithContext(ctx).MakeNode("small", "interrupted")
+	var notmade *NotMadeError
+	if err == nil || errors.As(err, &notmade) {
+		t.Fatalf("MakeNode got error %v, want one that isn't a NotMadeError", err)
+	}
+	if fake.Instance("us-east1-b", "interrupted") == nil {
+		t.Fatal("the interrupted insert didn't make an instance")
+	}
+
+	fake.Stall = false
+	if err := c.CleanUpFailedNode(&bytes.Buffer{}, "small", "interrupted", false); err != nil {
+		t.Fatalf("CleanUpFailedNode: %v", err)
+	}
+	if fake.Instance("us-east1-b", "interrupted") != nil {
+		t.Error("CleanUpFailedNode didn't delete the node")
+	}
+}
+
+func TestMakeNodeInterruptedReservation(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.settings.InstanceTypes["server"] = config.InstanceConfig{
+		Extends:    "small",
+		ExternalIp: "static",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	defer func(d time.Duration) { releaseTimeout = d }(releaseTimeout)
+	releaseTimeout = 200 * time.Millisecond
+	fake.Stall = true
+
+	// Releasing the address outlives the interrupted reservation.
+	_, err := c.WithContext(ctx).MakeNode("server", "interrupted")
+	var notmade *NotMadeError
+	if !errors.As(err, &notmade) {
+		t.Errorf("MakeNode got error %v, want a NotMadeError", err)
+	}
+	if fake.Address("us-east1", "interrupted-ip") != nil {
+		t.Error("MakeNode didn't release the static address after the reservation was interrupted")
+	}
+}
+
+func TestWaitForOperationWarnings(t *testing.T) {
+	c, fake := newTestClient(t)
+	if _, err := c.MakeNode("small", "warned"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+
+	var progress bytes.Buffer
+	c.Progress = &progress
+	fake.Warnings = []*compute.OperationWarnings{
+		{Code: "DISK_SIZE_LARGER_THAN_IMAGE_SIZE", Message: "Disk size: '20 GB' is larger than image size: '10 GB'."},
+	}
+	if err := c.Stop("", "warned"); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if want := "stop of warned in us-east1-b: warning: Disk size: '20 GB' is larger than image size: '10 GB'. (DISK_SIZE_LARGER_THAN_IMAGE_SIZE)\n"; !strings.Contains(progress.String(), want) {
+		t.Errorf("progress %q doesn't include the warning %q", progress.String(), want)
+	}
+}
+
+func TestWaitForOperationDeadline(t *testing.T) {
+	c, fake := newTestClient(t)
+	if _, err := c.MakeNode("small", "stuck"); err != nil {
+		t.Fatalf("MakeNode: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	c.ctx = ctx
+	var progress bytes.Buffer
+	c.Progress = &progress
+	fake.Stall = true
+
+	start := time.Now()
+	err := c.Stop("", "stuck")
+	if err == nil {
+		t.Fatal("Stop of a stalled operation should fail")
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) || !strings.Contains(err.Error(), "gave up waiting for stop of stuck") {
+		t.Errorf("Stop got error %v, want it to give up at the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Stop took %v to give up", elapsed)
+	}
+	if want := "stop of stuck in us-east1-b: RUNNING 0%\n"; !strings.HasPrefix(progress.String(), want) {
+		t.Errorf("progress got %q, want it to start with %q", progress.String(), want)
+	}
+}
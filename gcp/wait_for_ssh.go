@@ -1,21 +1,26 @@
 package gcp
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/rjkroege/gocloud/config"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
-// TODO(rjk): Figure out how to write nice tests for all of this.
-
 // MakeSshClientConfig populates an ssh.ClientConfig for reuse by each
-// connection attempt.
-func MakeSshClientConfig(settings *config.Settings) (*ssh.ClientConfig, error) {
+// connection attempt to the node with host key hostkey. A nil hostkey
+// accepts any host key.
+func MakeSshClientConfig(settings *config.Settings, hostkey ssh.PublicKey) (*ssh.ClientConfig, error) {
 	// username
 	userinfo, err := user.Current()
 	if err != nil {
@@ -35,54 +40,132 @@ func MakeSshClientConfig(settings *config.Settings) (*ssh.ClientConfig, error) {
 		return nil, fmt.Errorf("unable to parse private key %q: %v", sshpath, err)
 	}
 
+	// Nodes made before gocloud delivered host keys have only the token
+	// check to show that they are who they claim to be.
+	hostkeycallback := ssh.InsecureIgnoreHostKey()
+	if hostkey != nil {
+		hostkeycallback = ssh.FixedHostKey(hostkey)
+	}
+
 	config := &ssh.ClientConfig{
 		// needs to come out of the right place
 		User:            userinfo.Username,
 		Timeout:         time.Second,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostkeycallback,
 		Auth: []ssh.AuthMethod{
 			// Use the PublicKeys method for remote authentication.
 			ssh.PublicKeys(signer),
 		},
 	}
+	if hostkey != nil {
+		config.HostKeyAlgorithms = []string{hostkey.Type()}
+	}
 	return config, nil
 }
 
 // WaitForSsh waits for a ssh server to be up on the newly created node.
 // Run this after making the node.
 func WaitForSsh(settings *config.Settings, ni *NodeInfo) (*ssh.Client, error) {
+	return WaitForSshContext(context.Background(), settings, ni)
+}
+
+// WaitForSshContext is WaitForSsh that gives up when ctx is done.
+func WaitForSshContext(ctx context.Context, settings *config.Settings, ni *NodeInfo) (*ssh.Client, error) {
 	log.Println("run WaitForSsh")
-	sshconf, err := MakeSshClientConfig(settings)
+	sshconf, err := MakeSshClientConfig(settings, ni.HostKey)
 	if err != nil {
 		return nil, fmt.Errorf("can't MakeSshClientConfig: %v", err)
 	}
 
+	var jump *ssh.Client
+	if ni.JumpHost != "" {
+		if jump, err = dialJumpHost(sshconf, ni.JumpHost); err != nil {
+			return nil, fmt.Errorf("can't reach jump host %s: %v", ni.JumpHost, err)
+		}
+	}
+
 	// wait for the ssh to come up
+	var lasterr error
 	for i := 0; i < 12; i++ {
 		// Wait a bit for the GCP to have done something.
 		delayms := time.Duration(64*(1<<i)) * time.Millisecond
 		log.Printf("wating for ssh %v...", delayms)
 		delay := time.NewTimer(delayms)
-		<-delay.C
+		select {
+		case <-delay.C:
+		case <-ctx.Done():
+			delay.Stop()
+			if jump != nil {
+				jump.Close()
+			}
+			return nil, fmt.Errorf("gave up waiting for ssh on %s: %v", ni.Name, ctx.Err())
+		}
 
 		log.Println("polling for the instance ssh up")
 
-		switch client, err := connectToSsh(sshconf, ni.Ssh()); {
+		switch client, err := connectToSsh(sshconf, jump, ni.Ssh()); {
 		case err == nil:
 			log.Println("ssh is running")
+			if jump != nil {
+				go func() {
+					client.Wait()
+					jump.Close()
+				}()
+			}
 			return client, nil
 		case err != nil: // and more stuffs.
+			// A host key mismatch can be the node's ssh server starting
+			// before the node has installed its delivered host key.
 			log.Printf("no ssh yet %v", err)
+			lasterr = err
 		}
 	}
-	return nil, fmt.Errorf("too many tries failing to get ssh for %s", ni.Name)
+	if jump != nil {
+		jump.Close()
+	}
+	return nil, fmt.Errorf("too many tries failing to get ssh for %s: %v", ni.Name, lasterr)
 }
 
-func connectToSsh(sshconf *ssh.ClientConfig, addr string) (*ssh.Client, error) {
-	client, err := ssh.Dial("tcp", addr, sshconf)
+// connectToSsh connects to the ssh server at addr, through the jump host
+// connection jump if it isn't nil.
+func connectToSsh(sshconf *ssh.ClientConfig, jump *ssh.Client, addr string) (*ssh.Client, error) {
+	if jump == nil {
+		return ssh.Dial("tcp", addr, sshconf)
+	}
+
+	conn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	cc, chans, reqs, err := ssh.NewClientConn(conn, addr, sshconf)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	return ssh.NewClient(cc, chans, reqs), nil
+}
+
+// dialJumpHost connects to the ssh jump host jumphost, given as
+// [user@]host[:port]. It authenticates like sshconf and checks the jump
+// host's key against ~/.ssh/known_hosts.
+func dialJumpHost(sshconf *ssh.ClientConfig, jumphost string) (*ssh.Client, error) {
+	conf := *sshconf
+	addr := jumphost
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		conf.User, addr = addr[:i], addr[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
 
-	return client, nil
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("no home, can't read known_hosts: %v", err)
+	}
+	conf.HostKeyCallback, err = knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("can't read known_hosts: %v", err)
+	}
+	conf.HostKeyAlgorithms = nil
+	return ssh.Dial("tcp", addr, &conf)
 }
@@ -1,17 +1,44 @@
 module github.com/rjkroege/gocloud
 
-go 1.15
+go 1.21
 
 require (
-	cloud.google.com/go v0.73.0
+	cloud.google.com/go/compute/metadata v0.5.2
 	github.com/BurntSushi/toml v1.3.2
 	github.com/alecthomas/kong v0.6.1
-	github.com/google/go-cmp v0.5.4
+	github.com/google/go-cmp v0.6.0
 	github.com/sanity-io/litter v1.3.0
-	github.com/stretchr/testify v1.8.0 // indirect
-	golang.org/x/crypto v0.9.0
-	golang.org/x/oauth2 v0.0.0-20201203001011-0b49973bad19
-	google.golang.org/api v0.36.0
-	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	golang.org/x/crypto v0.28.0
+	golang.org/x/oauth2 v0.23.0
+	google.golang.org/api v0.200.0
 	gopkg.in/yaml.v2 v2.2.4
 )
+
+require (
+	cloud.google.com/go/auth v0.9.8 // indirect
+	cloud.google.com/go/auth/oauth2adapt v0.2.4 // indirect
+	github.com/alecthomas/repr v0.2.0 // indirect
+	github.com/felixge/httpsnoop v1.0.4 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
+	github.com/google/s2a-go v0.1.8 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/googleapis/enterprise-certificate-proxy v0.3.4 // indirect
+	github.com/googleapis/gax-go/v2 v2.13.0 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/rogpeppe/go-internal v1.12.0 // indirect
+	go.opencensus.io v0.24.0 // indirect
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.54.0 // indirect
+	go.opentelemetry.io/otel v1.29.0 // indirect
+	go.opentelemetry.io/otel/metric v1.29.0 // indirect
+	go.opentelemetry.io/otel/trace v1.29.0 // indirect
+	golang.org/x/net v0.30.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	golang.org/x/text v0.19.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20240930140551-af27646dc61f // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241007155032-5fefd90f89a9 // indirect
+	google.golang.org/grpc v1.67.1 // indirect
+	google.golang.org/protobuf v1.35.1 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)